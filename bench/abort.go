@@ -0,0 +1,114 @@
+package bench
+
+import (
+	"fmt"
+	"time"
+)
+
+// StopAborted means one of the AbortConditions was met.
+const StopAborted StopReason = "aborted"
+
+const (
+	defaultErrorRateWindow = 10 * time.Second
+
+	// minErrorRateWindowRequests keeps a handful of early failures from
+	// aborting the benchmark before the error rate means anything.
+	minErrorRateWindowRequests = 10
+)
+
+// AbortConditions stop a Benchmark early when the system under test is
+// clearly failing, rather than keep loading it for the full duration. They
+// are evaluated live by the collector, a zero value disables a condition.
+type AbortConditions struct {
+	// MaxErrorRate is the highest percentage of failed requests allowed
+	// over the sliding ErrorRateWindow, which defaults to 10s. The window
+	// moves by stats intervals and must contain at least 10 requests.
+	MaxErrorRate    float64       `yaml:"MaxErrorRate"`
+	ErrorRateWindow time.Duration `yaml:"ErrorRateWindow"`
+
+	// MaxConsecutiveFailures is the highest number of requests in a row
+	// allowed to fail.
+	MaxConsecutiveFailures uint64 `yaml:"MaxConsecutiveFailures"`
+
+	// MaxP99 is the highest p99 latency allowed in MaxP99Intervals stats
+	// intervals in a row, which defaults to 1.
+	MaxP99          time.Duration `yaml:"MaxP99"`
+	MaxP99Intervals int           `yaml:"MaxP99Intervals"`
+}
+
+// SetAbortConditions makes the benchmark stop as soon as one of the
+// conditions is met, the Summary is then marked as aborted. It must be called
+// before Run.
+func (b *Benchmark) SetAbortConditions(conditions AbortConditions) {
+	if conditions.ErrorRateWindow == 0 {
+		conditions.ErrorRateWindow = defaultErrorRateWindow
+	}
+	if conditions.MaxP99Intervals == 0 {
+		conditions.MaxP99Intervals = 1
+	}
+
+	windowIntervals := int((conditions.ErrorRateWindow + b.statsInterval - 1) / b.statsInterval)
+	b.abort = &abortMonitor{
+		conditions: conditions,
+		window:     make([]IntervalStats, 0, windowIntervals),
+	}
+}
+
+// abortMonitor tracks the state needed to evaluate AbortConditions, it is
+// only used by the collector.
+type abortMonitor struct {
+	conditions          AbortConditions
+	consecutiveFailures uint64
+	slowIntervals       int
+	window              []IntervalStats // the last intervals of the error rate window
+}
+
+// observe checks the outcome of a single request, returning the reason to
+// abort or an empty string.
+func (m *abortMonitor) observe(err error) string {
+	if err == nil {
+		m.consecutiveFailures = 0
+		return ""
+	}
+
+	m.consecutiveFailures++
+	if limit := m.conditions.MaxConsecutiveFailures; limit > 0 && m.consecutiveFailures >= limit {
+		return fmt.Sprintf("%d consecutive failures, last: %v", m.consecutiveFailures, err)
+	}
+	return ""
+}
+
+// interval checks the statistics of a completed interval, returning the
+// reason to abort or an empty string.
+func (m *abortMonitor) interval(stats IntervalStats) string {
+	if limit := m.conditions.MaxErrorRate; limit > 0 {
+		if len(m.window) == cap(m.window) {
+			m.window = append(m.window[:0], m.window[1:]...)
+		}
+		m.window = append(m.window, stats)
+
+		var successTotal, errorTotal uint64
+		for _, s := range m.window {
+			successTotal += s.SuccessTotal
+			errorTotal += s.ErrorTotal
+		}
+		if total := successTotal + errorTotal; total >= minErrorRateWindowRequests {
+			if rate := float64(errorTotal) * 100 / float64(total); rate > limit {
+				return fmt.Sprintf("error rate %.2f%% > %g%% over the last %v", rate, limit, m.conditions.ErrorRateWindow)
+			}
+		}
+	}
+
+	if limit := m.conditions.MaxP99; limit > 0 {
+		if stats.SuccessTotal > 0 && stats.P99 > limit {
+			m.slowIntervals++
+		} else {
+			m.slowIntervals = 0
+		}
+		if m.slowIntervals >= m.conditions.MaxP99Intervals {
+			return fmt.Sprintf("p99 %v > %v for %d intervals", stats.P99, limit, m.slowIntervals)
+		}
+	}
+
+	return ""
+}
@@ -4,6 +4,11 @@ Package bench provides a generic framework for performing latency benchmarks.
 package bench
 
 import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
 	"regexp"
 	"sync"
 	"sync/atomic"
@@ -18,7 +23,16 @@ import (
 const (
 	minRecordableLatencyNS = 1000000
 	maxRecordableLatencyNS = 100000000000
+	maxRecordableCount     = 1000000000
 	sigFigs                = 5
+
+	// intervalSigFigs is the precision of the per-interval histogram, which is
+	// only used to derive a handful of percentiles for each interval.
+	intervalSigFigs = 3
+
+	// defaultStatsInterval is the length of the intervals over which the
+	// collector aggregates latency, throughput and error statistics.
+	defaultStatsInterval = time.Second
 )
 
 // RequesterFactory creates new Requesters.
@@ -40,6 +54,112 @@ type Requester interface {
 	Teardown() error
 }
 
+// RequestDetails describes a request issued by a DetailedRequester. Besides
+// the latency and error of every Requester, the Benchmark aggregates the
+// status codes, bytes transferred, server times and tags of the requests.
+type RequestDetails struct {
+	// Target is the URL or scenario name the request was sent to.
+	Target string
+
+	// StatusCode is the protocol status code of the response, HasStatus
+	// reports whether there is one. Zero is a valid status code of some
+	// protocols, e.g. OK in gRPC.
+	StatusCode int
+	HasStatus  bool
+
+	// BytesSent is the size of the request.
+	BytesSent int64
+
+	// BytesReceived is the size of the response.
+	BytesReceived int64
+
+	// ServerTime is the processing time reported by the server, e.g. in a
+	// Server-Timing header, zero if none was reported.
+	ServerTime time.Duration
+
+	// Tags are custom labels of the request, e.g. whether the response was
+	// cached. The requests are counted by tag value.
+	Tags map[string]string
+}
+
+// DetailedRequester is a Requester which can describe the last request it
+// issued. The target, status code and bytes received are included in the raw
+// request log.
+type DetailedRequester interface {
+	Requester
+
+	// LastRequestDetails returns the details of the last Request call.
+	LastRequestDetails() RequestDetails
+}
+
+// Timing is an additional duration measured by a TimingRequester, e.g. the
+// handshake of a connection. Timings are aggregated into one histogram per
+// name, separate from the request latency.
+type Timing struct {
+	Name     string
+	Duration time.Duration
+}
+
+// TimingRequester is a Requester which measures additional timings.
+type TimingRequester interface {
+	Requester
+
+	// TakeTimings returns the timings measured since the last call, it's
+	// called after Setup and after every Request.
+	TakeTimings() []Timing
+}
+
+// Count is a number counted by a CountingRequester for a request, e.g. the
+// events of a streamed response. Counts are aggregated into one distribution
+// per name.
+type Count struct {
+	Name  string
+	Value int64
+}
+
+// CountingRequester is a Requester which counts something per request.
+type CountingRequester interface {
+	Requester
+
+	// TakeCounts returns the counts of the last Request call.
+	TakeCounts() []Count
+}
+
+// ErrSourceExhausted is returned by Requester.Request when it has no more
+// requests to issue, e.g. because it replayed its whole corpus. The call isn't
+// counted as a request and the Benchmark stops.
+var ErrSourceExhausted = errors.New("request source exhausted")
+
+// StopReason is the termination condition which ended a Benchmark run.
+type StopReason string
+
+const (
+	// StopDuration means the configured duration elapsed.
+	StopDuration StopReason = "duration"
+
+	// StopMaxRequests means the configured number of requests was sent.
+	StopMaxRequests StopReason = "max requests"
+
+	// StopSourceExhausted means a Requester returned ErrSourceExhausted.
+	StopSourceExhausted StopReason = "source exhausted"
+)
+
+// Mode is the way a Benchmark issues requests.
+type Mode string
+
+const (
+	// OpenLoop issues requests at the specified rate regardless of how fast
+	// the system under test responds, the rate is divided across the number
+	// of configured connections.
+	OpenLoop Mode = "open-loop"
+
+	// ClosedLoop has every connection issue requests back-to-back, the next
+	// request is only sent after the previous one completed (and the think
+	// time elapsed). It measures the maximum throughput at a given
+	// concurrency.
+	ClosedLoop Mode = "closed-loop"
+)
+
 // Benchmark performs a system benchmark by attempting to issue requests at a
 // specified rate and capturing the latency distribution. The request rate is
 // divided across the number of configured connections.
@@ -60,6 +180,74 @@ type Benchmark struct {
 	timelySends      uint64
 	lateSends        uint64
 	errors           map[string]int
+	results          resultTotals
+	statsInterval    time.Duration
+	intervals        []IntervalStats
+	inFlight         int64
+	mode             Mode
+	thinkTime        time.Duration
+	maxRequests      uint64
+	startAt          time.Time
+	ran              int32 // set by the first RunContext
+	start            time.Time
+	stopCh           chan struct{}
+	stopOnce         sync.Once
+	stopReason       StopReason
+	abort            *abortMonitor
+	abortReason      string
+	controlMu        sync.Mutex
+	resumeCh         chan struct{} // set while paused
+	paused           int32
+	rateChanged      chan struct{}
+	targetRate       float64 // requestRate as changed while running, guarded by controlMu
+	rateTimeline     []RateChange
+	metrics          *metrics
+	servers          []*benchmarkServer // attached by ServeMetrics and ServeControl
+	rawLog           *rawLog
+	timingsMu        sync.Mutex
+	timings          map[string]*hdrhistogram.Histogram // guarded by timingsMu
+	counts           map[string]*hdrhistogram.Histogram // guarded by timingsMu
+	overhead         *overheadMonitor
+	overheadStats    *OverheadStats
+	forceTightTicker bool
+	outputJSON       bool
+	output           io.Writer
+	logger           *log.Logger
+	observers        []Observer
+}
+
+// IntervalStats contains the statistics the collector aggregated over one
+// interval of the benchmark run. Requests are attributed to the interval in
+// which they completed.
+type IntervalStats struct {
+	Start        time.Time
+	Duration     time.Duration
+	SuccessTotal uint64
+	ErrorTotal   uint64
+	P50          time.Duration
+	P90          time.Duration
+	P99          time.Duration
+	Max          time.Duration
+}
+
+// sample is the outcome of a single request, sent by a worker to the
+// collector.
+type sample struct {
+	client   uint64
+	intended time.Time
+	sent     time.Time
+	latency  int64
+	err      error
+	details  RequestDetails
+}
+
+// New creates a Benchmark with the given options, it returns an error if they
+// are invalid.
+func New(opts Options) (*Benchmark, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return newBenchmark(opts), nil
 }
 
 // NewBenchmark creates a Benchmark which runs a system benchmark using the
@@ -67,109 +255,430 @@ type Benchmark struct {
 // requests per second to issue. This value is divided across the number of
 // connections specified, so if requestRate is 50,000 and connections is 10,
 // each connection will attempt to issue 5,000 requests per second. A zero
-// value disables rate limiting entirely. The duration argument specifies how
-// long to run the benchmark.
+// value disables rate limiting entirely and runs a ClosedLoop benchmark, where
+// each connection issues requests back-to-back. The duration argument
+// specifies how long to run the benchmark, a zero value runs it until
+// another termination condition is met (see SetMaxRequests and
+// ErrSourceExhausted). Progress messages are printed to stdout and problems
+// logged to stderr, use New to configure them.
 func NewBenchmark(factory RequesterFactory, requestRate, connections uint64, duration time.Duration, baseLatency time.Duration) *Benchmark {
+	return newBenchmark(Options{
+		Factory:     factory,
+		RequestRate: requestRate,
+		Connections: connections,
+		Duration:    duration,
+		BaseLatency: baseLatency,
+		Output:      os.Stdout,
+		Logger:      log.New(os.Stderr, "", log.LstdFlags),
+	})
+}
 
+func newBenchmark(opts Options) *Benchmark {
+	connections := opts.Connections
 	if connections == 0 {
 		connections = 1
 	}
 
-	if requestRate <= 0 {
-		log.Panicln("RequestRate must be positive")
+	mode := OpenLoop
+	var expectedInterval time.Duration
+	if opts.RequestRate == 0 {
+		mode = ClosedLoop
+	} else {
+		expectedInterval = time.Duration(float64(time.Second) / float64(opts.RequestRate))
 	}
 
-	return &Benchmark{
+	output := opts.Output
+	if output == nil {
+		output = ioutil.Discard
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.New(ioutil.Discard, "", 0)
+	}
+
+	b := &Benchmark{
 		connections:      connections,
-		requestRate:      float64(requestRate),
-		duration:         duration,
-		baseLatency:      baseLatency,
-		expectedInterval: time.Duration(float64(time.Second) / float64(requestRate)),
+		requestRate:      float64(opts.RequestRate),
+		targetRate:       float64(opts.RequestRate),
+		duration:         opts.Duration,
+		baseLatency:      opts.BaseLatency,
+		mode:             mode,
+		thinkTime:        opts.ThinkTime,
+		maxRequests:      opts.MaxRequests,
+		startAt:          opts.StartAt,
+		expectedInterval: expectedInterval,
 		successHistogram: hdrhistogram.New(minRecordableLatencyNS, maxRecordableLatencyNS, sigFigs),
-		factory:          factory,
-		errors:           make(map[string]int)}
+		factory:          opts.Factory,
+		errors:           make(map[string]int),
+		results:          newResultTotals(),
+		timings:          make(map[string]*hdrhistogram.Histogram),
+		counts:           make(map[string]*hdrhistogram.Histogram),
+		statsInterval:    defaultStatsInterval,
+		stopCh:           make(chan struct{}),
+		rateChanged:      make(chan struct{}, 1),
+		forceTightTicker: opts.TightTicker,
+		outputJSON:       opts.OutputJSON,
+		output:           output,
+		logger:           logger,
+		observers:        append([]Observer(nil), opts.Observers...),
+	}
+	if opts.Abort != nil {
+		b.SetAbortConditions(*opts.Abort)
+	}
+	return b
+}
+
+// SetThinkTime sets the time each connection of a ClosedLoop benchmark waits
+// after a request completes before issuing the next one. It must be called
+// before Run and has no effect on OpenLoop benchmarks.
+func (b *Benchmark) SetThinkTime(thinkTime time.Duration) {
+	b.thinkTime = thinkTime
+}
+
+// SetMaxRequests stops the benchmark once n requests were sent, or when the
+// duration elapses if that happens first. A zero value doesn't limit the
+// number of requests. It must be called before Run.
+func (b *Benchmark) SetMaxRequests(n uint64) {
+	b.maxRequests = n
+}
+
+// stop ends the benchmark run, only the first reason is recorded.
+func (b *Benchmark) stop(reason StopReason) {
+	b.stopOnce.Do(func() {
+		b.stopReason = reason
+		close(b.stopCh)
+	})
+}
+
+// abortRun stops the benchmark run because one of the AbortConditions was
+// met, unless it already stopped for another reason.
+func (b *Benchmark) abortRun(reason string) {
+	b.stopOnce.Do(func() {
+		b.stopReason = StopAborted
+		b.abortReason = reason
+		close(b.stopCh)
+	})
+}
+
+// startClock waits for the StartAt option, then records the start of the
+// run and arms the duration timer.
+func (b *Benchmark) startClock() time.Time {
+	if !b.startAt.IsZero() {
+		if wait := time.Until(b.startAt); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-b.stopCh:
+				timer.Stop()
+			}
+		} else {
+			b.logger.Printf("Start time %v passed %v ago, starting right away", b.startAt.UTC(), -wait)
+		}
+	}
+
+	b.controlMu.Lock()
+	b.start = time.Now()
+	b.rateTimeline = append(b.rateTimeline, RateChange{Time: b.start, Rate: b.targetRate, Paused: b.resumeCh != nil})
+	b.controlMu.Unlock()
+
+	if b.duration > 0 {
+		time.AfterFunc(b.duration, func() { b.stop(StopDuration) })
+	}
+	return b.start
+}
+
+// tickSent counts a tick picked up by a worker and stops the benchmark when
+// it was the last one allowed, in which case it returns true.
+func (b *Benchmark) tickSent(tick time.Time) bool {
+	for _, o := range b.observers {
+		o.TickEmitted(tick)
+	}
+	if ticks := atomic.AddUint64(&b.timelyTicks, 1); b.maxRequests > 0 && ticks >= b.maxRequests {
+		b.stop(StopMaxRequests)
+		return true
+	}
+	return false
 }
 
 // Run the benchmark and return a summary of the results. An error is returned
-// if something went wrong along the way.
+// if something went wrong along the way. The arguments override the
+// OutputJSON and TightTicker options, use RunContext to keep them.
 func (b *Benchmark) Run(outputJson bool, forceTightTicker bool) (*Summary, error) {
+	b.outputJSON = outputJson
+	b.forceTightTicker = forceTightTicker
+	return b.RunContext(context.Background())
+}
+
+// RunContext runs the benchmark and returns a summary of the results.
+// Canceling ctx stops the run the same way Stop does, the summary of the
+// requests made until then is still returned. Without Duration and
+// MaxRequests options ctx should carry a deadline, unless the Requesters run
+// out of requests. An error is returned if setting up a Requester or
+// something else along the way failed. A Benchmark runs only once, later
+// calls return an error.
+func (b *Benchmark) RunContext(ctx context.Context) (*Summary, error) {
+	if !atomic.CompareAndSwapInt32(&b.ran, 0, 1) {
+		return nil, errors.New("Benchmark already run, create a new one with New")
+	}
+	defer b.closeServers()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	requesters, err := b.setup()
+	if err != nil {
+		return nil, err
+	}
+
 	var (
-		ticker        = make(chan time.Time)
-		results       = make(chan int64, 100)
-		errors        = make(chan error, 100)
-		done          = make(chan struct{})
-		stopCollector = make(chan struct{})
-		wg            sync.WaitGroup
+		ticker    = make(chan time.Time)
+		samples   = make(chan sample, 100)
+		done      = make(chan struct{})
+		collected = make(chan struct{})
+		wg        sync.WaitGroup
 	)
 
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.Stop()
+		case <-b.stopCh:
+		}
+	}()
+
 	// Prepare connection benchmarks
-	wg.Add(int(b.connections))
-	for i := uint64(0); i < b.connections; i++ {
-		i := i
+	wg.Add(len(requesters))
+	for i, requester := range requesters {
+		i, requester := i, requester
 		go func() {
-			b.worker(b.factory.GetRequester(i), ticker, results, errors)
+			b.worker(uint64(i), requester, ticker, samples)
 			// log.Printf("Worker %d done\n", i)
 			wg.Done()
 		}()
 	}
 
+	// Watch the generator's own health
+	b.overhead = newOverheadMonitor()
+	go b.overhead.run()
+
 	// Prepare ticker
-	go b.tickerFunc(done, ticker, forceTightTicker)
+	go b.tickerFunc(done, ticker)
+
+	if b.rawLog != nil {
+		b.rawLog.start()
+	}
 
 	// Prepare results collector
 	go func() {
-		b.collectorFunc(stopCollector, results, errors)
+		b.collectorFunc(samples)
 		// log.Println("Collector done")
-		wg.Done()
+		close(collected)
 	}()
 
-	// Wait for completion of workers
+	// Wait for completion of workers and ticker
 	wg.Wait()
+	<-done
 	// log.Println("Workers have finished")
 
-	wg.Add(1)
-	close(stopCollector)
-	wg.Wait()
+	// The duration is the intended run time, otherwise the run lasted until
+	// the last request completed
+	if b.stopReason != StopDuration {
+		b.elapsed = time.Since(b.start)
+	}
+	fmt.Fprintln(b.output, "Stopped by:", b.stopReason)
+
+	// The collector records the samples which are still queued before it
+	// finishes
+	close(samples)
+	<-collected
 
 	// log.Println("Collector has finished")
 
-	fmt.Printf("Ticks=%d, TimelyTicks = %d, MissedTicks = %d, %.2f%% good\n", b.timelyTicks+b.missedTicks, b.timelyTicks, b.missedTicks, float64(b.timelyTicks)*100/float64(b.timelyTicks+b.missedTicks))
-	fmt.Printf("Sends=%d, TimelySends = %d, LateSends   = %d, %.2f%% good\n", b.timelySends+b.lateSends, b.timelySends, b.lateSends, float64(b.timelySends)*100/float64(b.timelySends+b.lateSends))
+	b.overheadStats = b.overhead.stop()
+
+	if b.rawLog != nil {
+		if err := b.rawLog.close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if b.mode == ClosedLoop {
+		fmt.Fprintf(b.output, "Closed loop: Sends=%d, Connections = %d, ThinkTime = %v\n", b.timelySends, b.connections, b.thinkTime)
+	} else {
+		fmt.Fprintf(b.output, "Ticks=%d, TimelyTicks = %d, MissedTicks = %d, %.2f%% good\n", b.timelyTicks+b.missedTicks, b.timelyTicks, b.missedTicks, float64(b.timelyTicks)*100/float64(b.timelyTicks+b.missedTicks))
+		fmt.Fprintf(b.output, "Sends=%d, TimelySends = %d, LateSends   = %d, %.2f%% good\n", b.timelySends+b.lateSends, b.timelySends, b.lateSends, float64(b.timelySends)*100/float64(b.timelySends+b.lateSends))
+	}
 
 	if len(b.errors) > 0 {
-		fmt.Println()
-		fmt.Println("Errors:")
+		fmt.Fprintln(b.output)
+		fmt.Fprintln(b.output, "Errors:")
 		for etext, count := range b.errors {
-			fmt.Println(count, "=", etext)
+			fmt.Fprintln(b.output, count, "=", etext)
 		}
-		fmt.Println()
+		fmt.Fprintln(b.output)
 	}
 
-	summary := b.summarize(outputJson)
+	summary := b.summarize()
 	return summary, nil
 }
 
-func (b *Benchmark) collectorFunc(doneCh <-chan struct{}, results <-chan int64, errors <-chan error) {
+// setup creates the Requesters of all connections and sets them up
+// concurrently. If any of them fails, the others are torn down again and the
+// first error is returned.
+func (b *Benchmark) setup() ([]Requester, error) {
+	var (
+		requesters = make([]Requester, b.connections)
+		errs       = make([]error, b.connections)
+		wg         sync.WaitGroup
+	)
+
+	// Factories don't need to be safe for concurrent use
+	for i := range requesters {
+		requesters[i] = b.factory.GetRequester(uint64(i))
+	}
+
+	wg.Add(len(requesters))
+	for i, requester := range requesters {
+		i, requester := i, requester
+		go func() {
+			defer wg.Done()
+			if errs[i] = requester.Setup(); errs[i] != nil {
+				return
+			}
+			if timing, ok := requester.(TimingRequester); ok {
+				b.recordTimings(timing.TakeTimings())
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		for j, requester := range requesters {
+			if errs[j] == nil {
+				b.teardown(requester)
+			}
+		}
+		return nil, fmt.Errorf("setting up connection %d: %v", i, err)
+	}
+	return requesters, nil
+}
+
+// teardown tears a Requester down, failures are only logged.
+func (b *Benchmark) teardown(requester Requester) {
+	if err := requester.Teardown(); err != nil {
+		b.logger.Println("Failure in Teardown:", err)
+	}
+}
+
+// collectorFunc records the samples of the workers until the samples channel
+// is closed.
+func (b *Benchmark) collectorFunc(samples <-chan sample) {
 	var (
 		baseLatency    = b.baseLatency.Nanoseconds()
 		successTotal   int64
 		avgRequestTime float64 // Average latency for processing requests
+		interval       = newIntervalCollector(time.Now())
+		intervalTicker = time.NewTicker(b.statsInterval)
 	)
+	defer intervalTicker.Stop()
+
 	for {
 		select {
-		case sample := <-results:
+		case s, ok := <-samples:
+			if !ok {
+				b.avgRequestTime = avgRequestTime
+				b.intervalCompleted(interval.flush(time.Now()))
+				return
+			}
+			if b.rawLog != nil {
+				b.rawLog.log(s)
+			}
+			if b.metrics != nil {
+				b.metrics.observe(s.latency-baseLatency, s.err)
+			}
+			b.requestCompleted(&s, baseLatency)
+			b.results.add(&s.details)
+			if b.abort != nil {
+				if reason := b.abort.observe(s.err); reason != "" {
+					b.abortRun(reason)
+				}
+			}
+			if s.err != nil {
+				b.errors[s.err.Error()]++
+				interval.errorTotal++
+				continue
+			}
 			successTotal++
-			maybePanic(b.successHistogram.RecordValue(sample - baseLatency))
-			avgRequestTime = (avgRequestTime*float64(successTotal-1) + float64(sample/1e6)) / float64(successTotal)
-		case err := <-errors:
-			b.errors[err.Error()]++
-		case <-doneCh:
-			b.avgRequestTime = avgRequestTime
-			return
+			if s.details.ServerTime > 0 {
+				b.recordTimings([]Timing{
+					{Name: serverTimeTiming, Duration: s.details.ServerTime},
+					{Name: clientTimeTiming, Duration: time.Duration(s.latency - baseLatency)},
+				})
+			}
+			b.overhead.observe(s)
+			recordValue(b.successHistogram, s.latency-baseLatency)
+			avgRequestTime = (avgRequestTime*float64(successTotal-1) + float64(s.latency/1e6)) / float64(successTotal)
+			interval.record(s.latency - baseLatency)
+		case now := <-intervalTicker.C:
+			stats := interval.flush(now)
+			b.intervalCompleted(stats)
+			if b.abort != nil {
+				if reason := b.abort.interval(stats); reason != "" {
+					b.abortRun(reason)
+				}
+			}
 		}
 	}
 }
 
+// intervalCollector accumulates the statistics of the current interval.
+type intervalCollector struct {
+	start        time.Time
+	histogram    *hdrhistogram.Histogram
+	successTotal uint64
+	errorTotal   uint64
+}
+
+func newIntervalCollector(start time.Time) *intervalCollector {
+	return &intervalCollector{
+		start:     start,
+		histogram: hdrhistogram.New(minRecordableLatencyNS, maxRecordableLatencyNS, intervalSigFigs),
+	}
+}
+
+func (c *intervalCollector) record(latency int64) {
+	c.successTotal++
+	// Values outside of the trackable range are still counted as successes,
+	// they just don't contribute to the interval percentiles.
+	_ = c.histogram.RecordValue(latency)
+}
+
+// flush returns the statistics of the current interval and starts a new one
+// at the given time.
+func (c *intervalCollector) flush(now time.Time) IntervalStats {
+	stats := IntervalStats{
+		Start:        c.start,
+		Duration:     now.Sub(c.start),
+		SuccessTotal: c.successTotal,
+		ErrorTotal:   c.errorTotal,
+		P50:          time.Duration(c.histogram.ValueAtQuantile(50)),
+		P90:          time.Duration(c.histogram.ValueAtQuantile(90)),
+		P99:          time.Duration(c.histogram.ValueAtQuantile(99)),
+		Max:          time.Duration(c.histogram.Max()),
+	}
+
+	c.start = now
+	c.successTotal = 0
+	c.errorTotal = 0
+	c.histogram.Reset()
+
+	return stats
+}
+
 func detectOsTimerResolution() time.Duration {
 	bestTimerRes := time.Hour
 
@@ -190,40 +699,75 @@ func detectOsTimerResolution() time.Duration {
 	return bestTimerRes
 }
 
-func (b *Benchmark) tickerFunc(doneCh chan<- struct{}, outCh chan<- time.Time, forceTightTicker bool) {
+func (b *Benchmark) tickerFunc(doneCh chan<- struct{}, outCh chan<- time.Time) {
+	if b.mode == ClosedLoop {
+		// let other go routines to start running
+		time.Sleep(200 * time.Millisecond)
+
+		fmt.Fprintln(b.output, "Using closed-loop ticker")
+		b.closedLoopTicker(doneCh, outCh)
+		return
+	}
+
 	timerRes := detectOsTimerResolution()
-	fmt.Printf("ExpectedInterval = %v, Detected OS timer resolution = %v\n", b.expectedInterval, timerRes)
-	if timerRes*3 > b.expectedInterval {
-		fmt.Println("WARNING! Detected OS timer resolution may not be sufficient for desired request rate")
+	fmt.Fprintf(b.output, "ExpectedInterval = %v, Detected OS timer resolution = %v\n", b.interval(), timerRes)
+	if timerRes*3 > b.interval() {
+		fmt.Fprintln(b.output, "WARNING! Detected OS timer resolution may not be sufficient for desired request rate")
 	}
 
 	// let other go routines to start running
 	time.Sleep(200 * time.Millisecond)
 
-	if !forceTightTicker && b.expectedInterval >= 7*timerRes {
-		fmt.Println("Using sleeping ticker")
+	if !b.forceTightTicker && b.interval() >= 7*timerRes {
+		fmt.Fprintln(b.output, "Using sleeping ticker")
 		b.sleepingTicker(doneCh, outCh)
 	} else {
-		fmt.Println("Using tight ticker")
+		fmt.Fprintln(b.output, "Using tight ticker")
 		b.tightTicker(doneCh, outCh)
 	}
 }
 
-func (b *Benchmark) tightTicker(doneCh chan<- struct{}, outCh chan<- time.Time) {
-	start := time.Now()
-	lastTick := start
+// closedLoopTicker hands a tick to a connection as soon as it is ready to
+// issue the next request, so there are no missed ticks.
+func (b *Benchmark) closedLoopTicker(doneCh chan<- struct{}, outCh chan<- time.Time) {
+	start := b.startClock()
 
-	var (
-		timelyTicks uint64
-		missedTicks uint64
-	)
+loop:
+	for {
+		b.waitIfPaused()
 
-	expectedInterval := b.expectedInterval
-	duration := b.duration
+		tick := time.Now()
+		select {
+		case outCh <- tick:
+			if b.tickSent(tick) {
+				close(outCh)
+				break loop
+			}
 
+		case <-b.stopCh:
+			close(outCh)
+			break loop
+		}
+	}
+
+	b.elapsed = time.Since(start)
+	close(doneCh)
+}
+
+func (b *Benchmark) tightTicker(doneCh chan<- struct{}, outCh chan<- time.Time) {
+	start := b.startClock()
+	lastTick := start
+
+loop:
 	for {
 		var thisTick time.Time
 
+		// Rate changes are picked up with the next tick
+		expectedInterval := b.interval()
+		if b.waitIfPaused() {
+			lastTick = time.Now()
+		}
+
 		for {
 			thisTick = time.Now()
 			if thisTick.Sub(lastTick) >= expectedInterval {
@@ -234,126 +778,228 @@ func (b *Benchmark) tightTicker(doneCh chan<- struct{}, outCh chan<- time.Time)
 
 		select {
 		case outCh <- thisTick:
-			timelyTicks++
+			b.tickSent(thisTick)
 		default:
-			missedTicks++
+			b.tickMissed(thisTick)
 		}
 
-		if thisTick.Sub(start) > duration {
+		select {
+		case <-b.stopCh:
 			// log.Println("Signaling DONE")
 			close(outCh)
-			break
+			break loop
+		default:
 		}
 	}
 
-	close(doneCh)
 	b.elapsed = time.Since(start)
-
-	b.timelyTicks = timelyTicks
-	b.missedTicks = missedTicks
+	close(doneCh)
 }
 
 func (b *Benchmark) sleepingTicker(doneCh chan<- struct{}, outCh chan<- time.Time) {
-	completion := time.After(b.duration)
-
-	inCh := time.Tick(b.expectedInterval)
+	start := b.startClock()
 
-	start := time.Now()
-
-	var (
-		timelyTicks uint64
-		missedTicks uint64
-	)
+	inTicker := time.NewTicker(b.interval())
+	defer inTicker.Stop()
 
 	// initial tick
 	outCh <- start
-	timelyTicks++
+	last := b.tickSent(start)
 
 loop:
-	for {
+	for !last {
 		select {
-		case t := <-inCh:
+		case t := <-inTicker.C:
+			if b.waitIfPaused() {
+				inTicker.Reset(b.interval())
+				continue
+			}
 			select {
 			case outCh <- t:
-				timelyTicks++
+				last = b.tickSent(t)
 			default:
-				missedTicks++
+				b.tickMissed(t)
 			}
 
-		case <-completion:
+		case <-b.rateChanged:
+			inTicker.Reset(b.interval())
+
+		case <-b.stopCh:
 			// log.Println("Signaling DONE")
-			close(outCh)
 			break loop
 		}
 	}
 
-	close(doneCh)
+	close(outCh)
 	b.elapsed = time.Since(start)
+	close(doneCh)
+}
 
-	b.timelyTicks = timelyTicks
-	b.missedTicks = missedTicks
+// recordTimings adds the timings measured by a TimingRequester to their
+// histograms.
+func (b *Benchmark) recordTimings(timings []Timing) {
+	if len(timings) == 0 {
+		return
+	}
+
+	b.timingsMu.Lock()
+	defer b.timingsMu.Unlock()
+	for _, t := range timings {
+		h := b.timings[t.Name]
+		if h == nil {
+			h = hdrhistogram.New(1, maxRecordableLatencyNS, intervalSigFigs)
+			b.timings[t.Name] = h
+		}
+		recordValue(h, t.Duration.Nanoseconds())
+	}
 }
 
-func maybePanic(err error) {
-	if err != nil {
-		log.Panic(err)
+// recordCounts adds the counts of a CountingRequester to their
+// distributions.
+func (b *Benchmark) recordCounts(counts []Count) {
+	if len(counts) == 0 {
+		return
+	}
+
+	b.timingsMu.Lock()
+	defer b.timingsMu.Unlock()
+	for _, c := range counts {
+		h := b.counts[c.Name]
+		if h == nil {
+			h = hdrhistogram.New(1, maxRecordableCount, intervalSigFigs)
+			b.counts[c.Name] = h
+		}
+		recordValue(h, c.Value)
 	}
 }
 
-func (b *Benchmark) worker(requester Requester, ticker <-chan time.Time, results chan<- int64, errors chan<- error) {
-	maybePanic(requester.Setup())
+// recordValue records v in the histogram, values outside of its trackable
+// range are clamped to it.
+func recordValue(h *hdrhistogram.Histogram, v int64) {
+	if v < 0 {
+		v = 0
+	}
+	if highest := h.HighestTrackableValue(); v > highest {
+		v = highest
+	}
+	_ = h.RecordValue(v)
+}
+
+// worker issues requests with a Requester which was set up already.
+func (b *Benchmark) worker(client uint64, requester Requester, ticker <-chan time.Time, samples chan<- sample) {
+	detailed, _ := requester.(DetailedRequester)
+	timing, _ := requester.(TimingRequester)
+	counting, _ := requester.(CountingRequester)
 
 	// initialized to 0 by default
 	var (
-		lateSends    uint64
-		timelySends  uint64
 		errorTotal   uint64
 		successTotal uint64
 	)
 
 	for tick := range ticker {
 		before := time.Now()
-		if before.Sub(tick) >= b.expectedInterval {
-			lateSends++
-		} else {
-			timelySends++
+		// Sends are counted as they happen, so they can be observed live.
+		// In a closed loop every send happens as soon as the connection is
+		// ready, so none of them is late.
+		sends := &b.timelySends
+		if b.mode == OpenLoop && before.Sub(tick) >= b.interval() {
+			sends = &b.lateSends
 		}
+		atomic.AddUint64(sends, 1)
 
+		for _, o := range b.observers {
+			o.RequestStarted(client, tick, before)
+		}
+		atomic.AddInt64(&b.inFlight, 1)
 		err := requester.Request()
 		latency := time.Since(before).Nanoseconds()
+		atomic.AddInt64(&b.inFlight, -1)
+
+		if err == ErrSourceExhausted {
+			// Nothing was sent
+			atomic.AddUint64(sends, ^uint64(0))
+			b.stop(StopSourceExhausted)
+			continue
+		}
+
+		// On Linux, sometimes time interval measurement comes back negative, report it as 0
+		if latency < 0 {
+			latency = 0
+		}
+
+		s := sample{client: client, intended: tick, sent: before, latency: latency, err: err}
+		if detailed != nil {
+			s.details = detailed.LastRequestDetails()
+		}
+		if timing != nil {
+			b.recordTimings(timing.TakeTimings())
+		}
+		if counting != nil {
+			b.recordCounts(counting.TakeCounts())
+		}
+		samples <- s
+
 		if err != nil {
 			errorTotal++
-			errors <- err
 		} else {
-			// On Linux, sometimes time interval measurement comes back negative, report it as 0
-			if latency < 0 {
-				latency = 0
-			}
-			results <- latency
 			successTotal++
 		}
+
+		if b.mode == ClosedLoop && b.thinkTime > 0 {
+			time.Sleep(b.thinkTime)
+		}
 	}
 
-	atomic.AddUint64(&b.lateSends, lateSends)
-	atomic.AddUint64(&b.timelySends, timelySends)
 	atomic.AddUint64(&b.errorTotal, errorTotal)
 	atomic.AddUint64(&b.successTotal, successTotal)
 
-	err := requester.Teardown()
-	if err != nil {
-		log.Println("Failure in Teardown:", err)
-	}
+	b.teardown(requester)
 }
 
 // summarize returns a Summary of the last benchmark run.
-func (b *Benchmark) summarize(outputJson bool) *Summary {
+func (b *Benchmark) summarize() *Summary {
+	summary := &Summary{
+		Mode:             b.mode,
+		ThinkTime:        b.thinkTime,
+		StopReason:       b.stopReason,
+		Aborted:          b.stopReason == StopAborted,
+		AbortReason:      b.abortReason,
+		RateTimeline:     b.rateTimeline,
+		SuccessTotal:     b.successTotal,
+		ErrorTotal:       b.errorTotal,
+		Start:            b.start,
+		TimeElapsed:      b.elapsed,
+		SuccessHistogram: hdrhistogram.Import(b.successHistogram.Export()),
+		AvgRequestTime:   b.avgRequestTime,
+		RequestRate:      b.requestRate,
+		Connections:      b.connections,
+		Errors:           formatErrors(b.errors),
+		TicksTimely:      b.timelyTicks,
+		TicksMissed:      b.missedTicks,
+		SendsTimely:      b.timelySends,
+		SendsLate:        b.lateSends,
+		OutputJson:       b.outputJSON,
+		Intervals:        b.intervals,
+		Overhead:         b.overheadStats,
+	}
+	b.results.summarize(summary)
+	summary.Timings = mergeHistograms(nil, b.timings)
+	summary.Counts = mergeHistograms(nil, b.counts)
+	summary.computeRatios()
+
+	return summary
+}
+
+// formatErrors returns the error counts keyed the way a Summary keys them.
+func formatErrors(errors map[string]int) map[string]int {
 
 	//Checks the list of target errors against the errors found during benchmarking
 	formattedErrors := make(map[string]int)
 	r := regexp.MustCompile(`Expected 200-response, but got (\d+)`)
 
 	//For every error that was found during benchmarking
-	for errorText, count := range b.errors {
+	for errorText, count := range errors {
 
 		//Use regex to extract error code
 		errorCodeMatches := r.FindStringSubmatch(errorText)
@@ -371,20 +1017,5 @@ func (b *Benchmark) summarize(outputJson bool) *Summary {
 		}
 	}
 
-	return &Summary{
-		SuccessTotal:     b.successTotal,
-		ErrorTotal:       b.errorTotal,
-		TimeElapsed:      b.elapsed,
-		SuccessHistogram: hdrhistogram.Import(b.successHistogram.Export()),
-		Throughput:       float64(b.successTotal+b.errorTotal) / b.elapsed.Seconds(),
-		AvgRequestTime:   b.avgRequestTime,
-		RequestRate:      b.requestRate,
-		Connections:      b.connections,
-		Errors:           formattedErrors,
-		TicksTimely:      b.timelyTicks,
-		TicksTimelyRatio: float64(b.timelyTicks) * 100 / float64(b.timelyTicks+b.missedTicks),
-		SendsTimely:      b.timelySends,
-		SendsTimelyRatio: float64(b.timelySends) * 100 / float64(b.timelySends+b.lateSends),
-		OutputJson:       outputJson,
-	}
+	return formattedErrors
 }
@@ -0,0 +1,140 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRequester fails every third request of a connection.
+type testRequester struct {
+	requests int
+}
+
+func (r *testRequester) Setup() error    { return nil }
+func (r *testRequester) Teardown() error { return nil }
+
+func (r *testRequester) Request() error {
+	r.requests++
+	if r.requests%3 == 0 {
+		return errors.New("third request")
+	}
+	return nil
+}
+
+type testRequesterFactory struct{}
+
+func (testRequesterFactory) GetRequester(uint64) Requester { return &testRequester{} }
+
+func TestRunRecordsEveryRequest(t *testing.T) {
+	b, err := New(Options{Factory: testRequesterFactory{}, Connections: 8, MaxRequests: 3000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "requests.bin")
+	if err := b.LogRequests(file, RawLogBinary); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := b.RunContext(context.Background())
+	if err != nil {
+		t.Fatalf("RunContext() error = %v", err)
+	}
+
+	total := summary.SuccessTotal + summary.ErrorTotal
+	if total < 3000 {
+		t.Errorf("%d requests, want at least 3000", total)
+	}
+	if n := uint64(summary.SuccessHistogram.TotalCount()); n != summary.SuccessTotal {
+		t.Errorf("%d latencies recorded, want %d", n, summary.SuccessTotal)
+	}
+	var errorTotal int
+	for _, count := range summary.Errors {
+		errorTotal += count
+	}
+	if uint64(errorTotal) != summary.ErrorTotal {
+		t.Errorf("%d errors recorded, want %d", errorTotal, summary.ErrorTotal)
+	}
+	if _, records := readRecords(t, file); uint64(len(records)) != total {
+		t.Errorf("%d requests logged, want %d", len(records), total)
+	}
+}
+
+func TestRunStartAt(t *testing.T) {
+	for _, delay := range []time.Duration{500 * time.Millisecond, -time.Second} {
+		var logged bytes.Buffer
+		startAt := time.Now().Add(delay)
+		b, err := New(Options{Factory: testRequesterFactory{}, RequestRate: 100, MaxRequests: 5, StartAt: startAt,
+			Logger: log.New(&logged, "", 0)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		summary, err := b.RunContext(context.Background())
+		if err != nil {
+			t.Fatalf("RunContext() error = %v", err)
+		}
+
+		if delay > 0 {
+			// The start is on time, give or take the timer resolution
+			if skew := summary.Start.Sub(startAt); skew < 0 || skew > 50*time.Millisecond {
+				t.Errorf("run started %v after StartAt", skew)
+			}
+		} else if !strings.Contains(logged.String(), "passed") {
+			t.Errorf("no warning about a past StartAt, logged %q", logged.String())
+		}
+	}
+}
+
+func TestRunTwice(t *testing.T) {
+	b, err := New(Options{Factory: testRequesterFactory{}, MaxRequests: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.RunContext(context.Background()); err != nil {
+		t.Fatalf("RunContext() error = %v", err)
+	}
+	if _, err := b.RunContext(context.Background()); err == nil || !strings.Contains(err.Error(), "already run") {
+		t.Errorf("second RunContext() error = %v, want already run", err)
+	}
+}
+
+func TestRunClosesServers(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	var benchmarks []*Benchmark
+	for i := 0; i < 2; i++ {
+		b, err := New(Options{Factory: testRequesterFactory{}, MaxRequests: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.ServeControl(addr); err != nil {
+			t.Fatal(err)
+		}
+		benchmarks = append(benchmarks, b)
+	}
+
+	// The second Benchmark took over the server, which outlives the first run
+	for i, b := range benchmarks {
+		if _, err := b.RunContext(context.Background()); err != nil {
+			t.Fatalf("RunContext() error = %v", err)
+		}
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		if up := err == nil; up != (i == 0) {
+			t.Errorf("after run %d server up = %v, want %v", i+1, up, i == 0)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package bench
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// StopRequested means the benchmark was stopped by calling Stop.
+const StopRequested StopReason = "stop requested"
+
+// RateChange is an entry of the rate timeline of a benchmark run, the target
+// request rate from Time on until the next change.
+type RateChange struct {
+	Time   time.Time
+	Rate   float64
+	Paused bool
+}
+
+// ControlStatus is the live state of a benchmark, as reported by the control
+// endpoint.
+type ControlStatus struct {
+	Mode        Mode
+	State       string
+	Rate        float64
+	Elapsed     string
+	Sent        uint64
+	MissedTicks uint64
+	InFlight    int64
+}
+
+// SetRate changes the target request rate of a running OpenLoop benchmark,
+// the ticker switches to it with the next tick. Note that the ticker type is
+// chosen for the initial rate.
+func (b *Benchmark) SetRate(rate uint64) error {
+	if b.mode == ClosedLoop {
+		return errors.New("closed-loop benchmarks have no rate")
+	}
+	if rate == 0 {
+		return errors.New("rate must be positive")
+	}
+
+	b.controlMu.Lock()
+	defer b.controlMu.Unlock()
+
+	b.targetRate = float64(rate)
+	atomic.StoreInt64((*int64)(&b.expectedInterval), int64(time.Duration(float64(time.Second)/float64(rate))))
+	b.recordRate(float64(rate), b.resumeCh != nil)
+
+	select {
+	case b.rateChanged <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Pause stops issuing requests until Resume is called, requests in flight
+// still complete. The paused time counts towards the duration.
+func (b *Benchmark) Pause() {
+	b.controlMu.Lock()
+	defer b.controlMu.Unlock()
+
+	if b.resumeCh == nil {
+		b.resumeCh = make(chan struct{})
+		atomic.StoreInt32(&b.paused, 1)
+		b.recordRate(b.targetRate, true)
+	}
+}
+
+// Resume continues issuing requests after Pause.
+func (b *Benchmark) Resume() {
+	b.controlMu.Lock()
+	defer b.controlMu.Unlock()
+
+	if b.resumeCh != nil {
+		close(b.resumeCh)
+		b.resumeCh = nil
+		atomic.StoreInt32(&b.paused, 0)
+		b.recordRate(b.targetRate, false)
+	}
+}
+
+// Stop ends the benchmark run early.
+func (b *Benchmark) Stop() {
+	b.stop(StopRequested)
+}
+
+// Status returns the live state of the benchmark.
+func (b *Benchmark) Status() ControlStatus {
+	b.controlMu.Lock()
+	defer b.controlMu.Unlock()
+
+	status := ControlStatus{
+		Mode:        b.mode,
+		State:       "starting",
+		Rate:        b.targetRate,
+		Sent:        atomic.LoadUint64(&b.timelySends) + atomic.LoadUint64(&b.lateSends),
+		MissedTicks: atomic.LoadUint64(&b.missedTicks),
+		InFlight:    atomic.LoadInt64(&b.inFlight),
+	}
+	if !b.start.IsZero() {
+		status.State = "running"
+		status.Elapsed = time.Since(b.start).Round(time.Millisecond).String()
+	}
+	if b.resumeCh != nil {
+		status.State = "paused"
+	}
+	select {
+	case <-b.stopCh:
+		status.State = "stopped"
+	default:
+	}
+	return status
+}
+
+// recordRate appends a change to the rate timeline, the caller must hold
+// controlMu. Changes before the start are covered by the initial entry.
+func (b *Benchmark) recordRate(rate float64, paused bool) {
+	if b.start.IsZero() {
+		return
+	}
+	b.rateTimeline = append(b.rateTimeline, RateChange{Time: time.Now(), Rate: rate, Paused: paused})
+}
+
+// interval returns the current expected interval between ticks.
+func (b *Benchmark) interval() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&b.expectedInterval)))
+}
+
+// waitIfPaused blocks while the benchmark is paused, returning whether it
+// was.
+func (b *Benchmark) waitIfPaused() bool {
+	if atomic.LoadInt32(&b.paused) == 0 {
+		return false
+	}
+
+	b.controlMu.Lock()
+	resumeCh := b.resumeCh
+	b.controlMu.Unlock()
+	if resumeCh == nil {
+		return false
+	}
+
+	select {
+	case <-resumeCh:
+	case <-b.stopCh:
+	}
+	return true
+}
+
+// ServeControl starts serving an HTTP control endpoint for the benchmark on
+// addr, which is meant for exploratory testing:
+//
+//	GET  /status           the live state as JSON
+//	POST /rate?rate=<n>    change the target request rate
+//	POST /pause, /resume   pause or resume issuing requests
+//	POST /stop             end the run
+//
+// All endpoints reply with the status. The server runs in the background and
+// is shut down when the run ends; a later Benchmark serving on the same addr
+// before then takes over the server. It must be called before Run.
+func (b *Benchmark) ServeControl(addr string) error {
+	srv, err := b.attachServer(addr)
+	if err != nil {
+		return err
+	}
+
+	srv.handle("/status", func(b *Benchmark, w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, b)
+	})
+	srv.handle("/rate", controlHandler(func(b *Benchmark, r *http.Request) error {
+		rate, err := strconv.ParseUint(r.FormValue("rate"), 10, 64)
+		if err != nil {
+			return errors.New("rate must be a positive integer")
+		}
+		return b.SetRate(rate)
+	}))
+	srv.handle("/pause", controlHandler(func(b *Benchmark, r *http.Request) error {
+		b.Pause()
+		return nil
+	}))
+	srv.handle("/resume", controlHandler(func(b *Benchmark, r *http.Request) error {
+		b.Resume()
+		return nil
+	}))
+	srv.handle("/stop", controlHandler(func(b *Benchmark, r *http.Request) error {
+		b.Stop()
+		return nil
+	}))
+	return nil
+}
+
+// controlHandler wraps an action changing the benchmark state into a handler
+// only accepting POST requests.
+func controlHandler(action func(b *Benchmark, r *http.Request) error) func(b *Benchmark, w http.ResponseWriter, r *http.Request) {
+	return func(b *Benchmark, w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := action(b, r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeStatus(w, b)
+	}
+}
+
+func writeStatus(w http.ResponseWriter, b *Benchmark) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(b.Status())
+}
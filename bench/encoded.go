@@ -0,0 +1,305 @@
+package bench
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+// EncodedSummary is the portable form of a Summary, with the latency
+// histogram encoded compactly, so it can be sent to another process or saved
+// and merged with other summaries later. The per interval statistics are not
+// included.
+type EncodedSummary struct {
+	Mode           Mode
+	ThinkTime      time.Duration
+	StopReason     StopReason
+	Aborted        bool
+	AbortReason    string
+	Connections    uint64
+	RequestRate    float64
+	Start          time.Time
+	TimeElapsed    time.Duration
+	SuccessTotal   uint64
+	ErrorTotal     uint64
+	AvgRequestTime float64
+	Errors         map[string]int
+	TicksTimely    uint64
+	TicksMissed    uint64
+	SendsTimely    uint64
+	SendsLate      uint64
+	BytesSent      uint64            `json:",omitempty"`
+	BytesReceived  uint64            `json:",omitempty"`
+	StatusCodes    map[int]uint64    `json:",omitempty"`
+	Tags           map[string]uint64 `json:",omitempty"`
+
+	// SuccessHistogram is the latency histogram of successful requests,
+	// encoded by EncodeHistogram.
+	SuccessHistogram []byte
+
+	// Timings and Counts are the histograms of the additional timings and
+	// counts, encoded by EncodeHistogram.
+	Timings map[string][]byte `json:",omitempty"`
+	Counts  map[string][]byte `json:",omitempty"`
+}
+
+// Encode returns the portable form of the summary.
+func (s *Summary) Encode() *EncodedSummary {
+	return &EncodedSummary{
+		Mode:             s.Mode,
+		ThinkTime:        s.ThinkTime,
+		StopReason:       s.StopReason,
+		Aborted:          s.Aborted,
+		AbortReason:      s.AbortReason,
+		Connections:      s.Connections,
+		RequestRate:      s.RequestRate,
+		Start:            s.Start,
+		TimeElapsed:      s.TimeElapsed,
+		SuccessTotal:     s.SuccessTotal,
+		ErrorTotal:       s.ErrorTotal,
+		AvgRequestTime:   s.AvgRequestTime,
+		Errors:           s.Errors,
+		TicksTimely:      s.TicksTimely,
+		TicksMissed:      s.TicksMissed,
+		SendsTimely:      s.SendsTimely,
+		SendsLate:        s.SendsLate,
+		BytesSent:        s.BytesSent,
+		BytesReceived:    s.BytesReceived,
+		StatusCodes:      s.StatusCodes,
+		Tags:             s.Tags,
+		SuccessHistogram: EncodeHistogram(s.SuccessHistogram),
+		Timings:          encodeHistograms(s.Timings),
+		Counts:           encodeHistograms(s.Counts),
+	}
+}
+
+// Decode returns the Summary the portable form was encoded from, the derived
+// statistics are recomputed.
+func (e *EncodedSummary) Decode() (*Summary, error) {
+	histogram, err := DecodeHistogram(e.SuccessHistogram)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Summary{
+		Mode:             e.Mode,
+		ThinkTime:        e.ThinkTime,
+		StopReason:       e.StopReason,
+		Aborted:          e.Aborted,
+		AbortReason:      e.AbortReason,
+		Connections:      e.Connections,
+		RequestRate:      e.RequestRate,
+		Start:            e.Start,
+		TimeElapsed:      e.TimeElapsed,
+		SuccessTotal:     e.SuccessTotal,
+		ErrorTotal:       e.ErrorTotal,
+		AvgRequestTime:   e.AvgRequestTime,
+		Errors:           e.Errors,
+		TicksTimely:      e.TicksTimely,
+		TicksMissed:      e.TicksMissed,
+		SendsTimely:      e.SendsTimely,
+		SendsLate:        e.SendsLate,
+		BytesSent:        e.BytesSent,
+		BytesReceived:    e.BytesReceived,
+		StatusCodes:      e.StatusCodes,
+		Tags:             e.Tags,
+		SuccessHistogram: histogram,
+	}
+	if s.Errors == nil {
+		s.Errors = make(map[string]int)
+	}
+	if s.Timings, err = decodeHistograms(e.Timings); err != nil {
+		return nil, fmt.Errorf("timing %v", err)
+	}
+	if s.Counts, err = decodeHistograms(e.Counts); err != nil {
+		return nil, fmt.Errorf("count %v", err)
+	}
+	s.computeRatios()
+	return s, nil
+}
+
+// SaveEncoded writes the portable form of the summary to a JSON file, which
+// LoadEncodedSummary reads back.
+func (s *Summary) SaveEncoded(file string) error {
+	data, err := json.MarshalIndent(s.Encode(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// LoadEncodedSummary reads a summary saved by SaveEncoded.
+func LoadEncodedSummary(file string) (*Summary, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var e EncodedSummary
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	s, err := e.Decode()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return s, nil
+}
+
+// computeRatios derives the throughput and timely ratios from the counters.
+func (s *Summary) computeRatios() {
+	s.Throughput = 0
+	if s.TimeElapsed > 0 {
+		s.Throughput = float64(s.SuccessTotal+s.ErrorTotal) / s.TimeElapsed.Seconds()
+	}
+	s.TicksTimelyRatio = float64(s.TicksTimely) * 100 / float64(s.TicksTimely+s.TicksMissed)
+	s.SendsTimelyRatio = float64(s.SendsTimely) * 100 / float64(s.SendsTimely+s.SendsLate)
+
+	// Ticks and send timeliness only make sense against a requested rate
+	if s.Mode == ClosedLoop {
+		s.TicksTimelyRatio = math.NaN()
+		s.SendsTimelyRatio = math.NaN()
+	}
+}
+
+// encodeHistograms encodes named histograms by EncodeHistogram.
+func encodeHistograms(histograms map[string]*hdrhistogram.Histogram) map[string][]byte {
+	if len(histograms) == 0 {
+		return nil
+	}
+	encoded := make(map[string][]byte, len(histograms))
+	for name, h := range histograms {
+		encoded[name] = EncodeHistogram(h)
+	}
+	return encoded
+}
+
+// decodeHistograms decodes named histograms encoded by encodeHistograms.
+func decodeHistograms(encoded map[string][]byte) (map[string]*hdrhistogram.Histogram, error) {
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+	histograms := make(map[string]*hdrhistogram.Histogram, len(encoded))
+	for name, data := range encoded {
+		h, err := DecodeHistogram(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		histograms[name] = h
+	}
+	return histograms, nil
+}
+
+// histogramEncodingVersion is the first byte of an encoded histogram.
+const histogramEncodingVersion = 1
+
+// EncodeHistogram encodes a histogram compactly: after the trackable range
+// and precision, the counts are written as zigzag varints where runs of zero
+// counts are collapsed into their negated length, all deflate compressed.
+func EncodeHistogram(h *hdrhistogram.Histogram) []byte {
+	snapshot := h.Export()
+
+	var raw bytes.Buffer
+	raw.WriteByte(histogramEncodingVersion)
+
+	varint := make([]byte, binary.MaxVarintLen64)
+	put := func(v int64) {
+		raw.Write(varint[:binary.PutVarint(varint, v)])
+	}
+	put(snapshot.LowestTrackableValue)
+	put(snapshot.HighestTrackableValue)
+	put(snapshot.SignificantFigures)
+	put(int64(len(snapshot.Counts)))
+
+	zeros := int64(0)
+	for _, count := range snapshot.Counts {
+		if count == 0 {
+			zeros++
+			continue
+		}
+		if zeros > 0 {
+			put(-zeros)
+			zeros = 0
+		}
+		put(count)
+	}
+	if zeros > 0 {
+		put(-zeros)
+	}
+
+	var compressed bytes.Buffer
+	w, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	_, _ = w.Write(raw.Bytes())
+	_ = w.Close()
+	return compressed.Bytes()
+}
+
+// DecodeHistogram decodes a histogram encoded by EncodeHistogram.
+func DecodeHistogram(data []byte) (*hdrhistogram.Histogram, error) {
+	raw, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid histogram: %v", err)
+	}
+
+	r := bytes.NewReader(raw)
+	if version, err := r.ReadByte(); err != nil || version != histogramEncodingVersion {
+		return nil, errors.New("invalid histogram: unsupported encoding")
+	}
+
+	var header [4]int64
+	for i := range header {
+		if header[i], err = binary.ReadVarint(r); err != nil {
+			return nil, errors.New("invalid histogram: truncated header")
+		}
+	}
+	low, high, sigFigs, countsLen := header[0], header[1], header[2], header[3]
+	if low < 1 || high < 2*low || sigFigs < 1 || sigFigs > 5 {
+		return nil, fmt.Errorf("invalid histogram: range %d-%d with %d significant figures", low, high, sigFigs)
+	}
+	if want := int64(len(hdrhistogram.New(low, high, int(sigFigs)).Export().Counts)); countsLen != want {
+		return nil, fmt.Errorf("invalid histogram: %d counts, expected %d", countsLen, want)
+	}
+
+	counts := make([]int64, 0, countsLen)
+	for {
+		v, err := binary.ReadVarint(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("invalid histogram: truncated counts")
+		}
+		if v >= 0 {
+			counts = append(counts, v)
+		} else {
+			// Compared this way round, so even the most negative v doesn't
+			// overflow
+			if v < int64(len(counts))-countsLen {
+				return nil, errors.New("invalid histogram: too many counts")
+			}
+			counts = append(counts, make([]int64, -v)...)
+		}
+		if int64(len(counts)) > countsLen {
+			return nil, errors.New("invalid histogram: too many counts")
+		}
+	}
+	if int64(len(counts)) != countsLen {
+		return nil, fmt.Errorf("invalid histogram: %d counts, expected %d", len(counts), countsLen)
+	}
+
+	return hdrhistogram.Import(&hdrhistogram.Snapshot{
+		LowestTrackableValue:  low,
+		HighestTrackableValue: high,
+		SignificantFigures:    sigFigs,
+		Counts:                counts,
+	}), nil
+}
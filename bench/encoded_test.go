@@ -0,0 +1,194 @@
+package bench
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+func TestHistogramRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		h      *hdrhistogram.Histogram
+		values []int64
+	}{
+		{"empty", hdrhistogram.New(minRecordableLatencyNS, maxRecordableLatencyNS, sigFigs), nil},
+		{"latencies", hdrhistogram.New(minRecordableLatencyNS, maxRecordableLatencyNS, sigFigs), []int64{1e6, 1e6, 2e6, 15e6, 250e6, 3e9, maxRecordableLatencyNS}},
+		{"counts", hdrhistogram.New(1, 1e6, intervalSigFigs), []int64{1, 1, 1, 2, 10, 999999}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, v := range tt.values {
+				if err := tt.h.RecordValue(v); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			decoded, err := DecodeHistogram(EncodeHistogram(tt.h))
+			if err != nil {
+				t.Fatalf("DecodeHistogram() error = %v", err)
+			}
+			if !reflect.DeepEqual(decoded.Export(), tt.h.Export()) {
+				t.Errorf("decoded histogram differs from the encoded one")
+			}
+			if decoded.TotalCount() != int64(len(tt.values)) || decoded.Max() != tt.h.Max() {
+				t.Errorf("decoded count, max = %d, %d, want %d, %d", decoded.TotalCount(), decoded.Max(), len(tt.values), tt.h.Max())
+			}
+		})
+	}
+}
+
+func TestHistogramEncodingIsCompact(t *testing.T) {
+	h := hdrhistogram.New(minRecordableLatencyNS, maxRecordableLatencyNS, sigFigs)
+	for i := int64(0); i < 10000; i++ {
+		_ = h.RecordValue(minRecordableLatencyNS + i*1000)
+	}
+	// A plain array of the counts would be megabytes
+	if n := len(EncodeHistogram(h)); n > 64<<10 {
+		t.Errorf("encoded histogram is %d bytes", n)
+	}
+}
+
+// deflate compresses raw histogram encodings for the invalid input tests.
+func deflate(t *testing.T, version byte, values ...int64) []byte {
+	t.Helper()
+
+	raw := []byte{version}
+	varint := make([]byte, binary.MaxVarintLen64)
+	for _, v := range values {
+		raw = append(raw, varint[:binary.PutVarint(varint, v)]...)
+	}
+
+	var compressed bytes.Buffer
+	w, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	_, _ = w.Write(raw)
+	_ = w.Close()
+	return compressed.Bytes()
+}
+
+func TestDecodeHistogramInvalid(t *testing.T) {
+	countsLen := int64(len(hdrhistogram.New(1, 1000, 1).Export().Counts))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"not deflate", []byte("garbage"), "invalid histogram"},
+		{"empty", deflate(t, histogramEncodingVersion), "truncated header"},
+		{"version", deflate(t, 2, 1, 1000, 1, countsLen), "unsupported encoding"},
+		{"range", deflate(t, histogramEncodingVersion, 0, 1000, 1, countsLen), "range 0-1000"},
+		{"significant figures", deflate(t, histogramEncodingVersion, 1, 1000, 6, countsLen), "with 6 significant figures"},
+		{"counts length", deflate(t, histogramEncodingVersion, 1, 1000, 1, countsLen+1), "counts, expected"},
+		{"too many counts", deflate(t, histogramEncodingVersion, 1, 1000, 1, countsLen, -countsLen, 1), "too many counts"},
+		{"too many zeros", deflate(t, histogramEncodingVersion, 1, 1000, 1, countsLen, 1, -countsLen), "too many counts"},
+		{"overflowing zeros", deflate(t, histogramEncodingVersion, 1, 1000, 1, countsLen, math.MinInt64), "too many counts"},
+		{"missing counts", deflate(t, histogramEncodingVersion, 1, 1000, 1, countsLen, 1), "1 counts, expected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeHistogram(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("DecodeHistogram() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func testSummary(t *testing.T, mode Mode, latencies ...int64) *Summary {
+	t.Helper()
+
+	s := &Summary{
+		Mode:             mode,
+		Connections:      2,
+		RequestRate:      100,
+		Start:            time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		TimeElapsed:      10 * time.Second,
+		SuccessTotal:     uint64(len(latencies)),
+		ErrorTotal:       1,
+		AvgRequestTime:   12.5,
+		Errors:           map[string]int{"Expected 200 got 503": 1},
+		TicksTimely:      uint64(len(latencies)),
+		TicksMissed:      1,
+		SendsTimely:      uint64(len(latencies)) + 1,
+		BytesSent:        100,
+		BytesReceived:    1000,
+		StatusCodes:      map[int]uint64{200: uint64(len(latencies)), 503: 1},
+		Tags:             map[string]uint64{"cache=hit": 1},
+		SuccessHistogram: hdrhistogram.New(minRecordableLatencyNS, maxRecordableLatencyNS, sigFigs),
+		Timings:          map[string]*hdrhistogram.Histogram{"Connect": hdrhistogram.New(1, maxRecordableLatencyNS, intervalSigFigs)},
+	}
+	for _, latency := range latencies {
+		recordValue(s.SuccessHistogram, latency)
+		recordValue(s.Timings["Connect"], latency/10)
+	}
+	s.computeRatios()
+	return s
+}
+
+func TestSummaryRoundTrip(t *testing.T) {
+	for _, mode := range []Mode{OpenLoop, ClosedLoop} {
+		t.Run(string(mode), func(t *testing.T) {
+			s := testSummary(t, mode, 2e6, 5e6, 40e6)
+			file := filepath.Join(t.TempDir(), "summary.json")
+			if err := s.SaveEncoded(file); err != nil {
+				t.Fatalf("SaveEncoded() error = %v", err)
+			}
+			loaded, err := LoadEncodedSummary(file)
+			if err != nil {
+				t.Fatalf("LoadEncodedSummary() error = %v", err)
+			}
+
+			// Histograms are compared by their snapshots, NaN ratios by
+			// their JSON form, where they are omitted
+			if !reflect.DeepEqual(loaded.SuccessHistogram.Export(), s.SuccessHistogram.Export()) {
+				t.Error("SuccessHistogram differs")
+			}
+			if !reflect.DeepEqual(loaded.Timings["Connect"].Export(), s.Timings["Connect"].Export()) {
+				t.Error("Timings differ")
+			}
+			want, _ := json.Marshal(s)
+			got, _ := json.Marshal(loaded)
+			if string(got) != string(want) {
+				t.Errorf("loaded summary = %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadEncodedSummaryInvalid(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"JSON", "{", "unexpected end of JSON input"},
+		{"histogram", `{"SuccessHistogram": "AAAA"}`, "invalid histogram"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, tt.name+".json")
+			if err := ioutil.WriteFile(file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadEncodedSummary(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), file) {
+				t.Errorf("LoadEncodedSummary() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
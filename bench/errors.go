@@ -0,0 +1,40 @@
+package bench
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"regexp"
+)
+
+var statusCodeRegexp = regexp.MustCompile(`Expected \d+[^\d]+(\d+)`)
+
+// ErrorCategory maps a request error to a short, low-cardinality category,
+// suitable for use as a metric label: "status_<code>" for unexpected status
+// codes, "timeout", "connection" for network errors and "other" otherwise.
+func ErrorCategory(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	if m := statusCodeRegexp.FindStringSubmatch(err.Error()); len(m) > 1 {
+		return "status_" + m[1]
+	}
+
+	if err == context.DeadlineExceeded {
+		return "timeout"
+	}
+
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+
+	if netErr, ok := err.(net.Error); ok {
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "connection"
+	}
+
+	return "other"
+}
@@ -0,0 +1,42 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+)
+
+// timeoutError is a net.Error which timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"success", nil, ""},
+		{"status", errors.New("Expected 200 got 503"), "status_503"},
+		{"status with names", errors.New("Expected 0 (OK) got 14 (Unavailable)"), "status_14"},
+		{"deadline", context.DeadlineExceeded, "timeout"},
+		{"net timeout", timeoutError{}, "timeout"},
+		{"url timeout", &url.Error{Op: "Get", URL: "http://localhost/", Err: timeoutError{}}, "timeout"},
+		{"connection", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, "connection"},
+		{"url connection", &url.Error{Op: "Get", URL: "http://localhost/", Err: &net.OpError{Op: "dial", Err: errors.New("refused")}}, "connection"},
+		{"other", errors.New("unexpected reply"), "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorCategory(tt.err); got != tt.want {
+				t.Errorf("ErrorCategory(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
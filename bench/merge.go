@@ -0,0 +1,96 @@
+package bench
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+// MergeSummaries combines the summaries of benchmarks which loaded the same
+// system at the same time, e.g. from several machines, into one: histograms,
+// counters, errors, timings, counts and result totals are added up, and the
+// run is considered to last from the earliest start to the latest end. The
+// per interval statistics and rate timelines are not merged.
+func MergeSummaries(summaries ...*Summary) (*Summary, error) {
+	if len(summaries) == 0 {
+		return nil, errors.New("no summaries to merge")
+	}
+
+	first := summaries[0]
+	merged := &Summary{
+		Mode:             first.Mode,
+		ThinkTime:        first.ThinkTime,
+		StopReason:       first.StopReason,
+		Start:            first.Start,
+		SuccessHistogram: hdrhistogram.New(first.SuccessHistogram.LowestTrackableValue(), first.SuccessHistogram.HighestTrackableValue(), int(first.SuccessHistogram.SignificantFigures())),
+		Errors:           make(map[string]int),
+		OutputJson:       first.OutputJson,
+	}
+
+	var (
+		end          time.Time
+		abortReasons []string
+		requestTime  float64 // weighted sum of the average request times
+	)
+	for _, s := range summaries {
+		if s.Mode != merged.Mode {
+			return nil, errors.New("can't merge " + string(merged.Mode) + " and " + string(s.Mode) + " summaries")
+		}
+
+		if s.Start.Before(merged.Start) {
+			merged.Start = s.Start
+		}
+		if e := s.Start.Add(s.TimeElapsed); e.After(end) {
+			end = e
+		}
+
+		if s.Aborted {
+			merged.Aborted = true
+			merged.StopReason = StopAborted
+			abortReasons = append(abortReasons, s.AbortReason)
+		}
+
+		merged.Connections += s.Connections
+		merged.RequestRate += s.RequestRate
+		merged.SuccessTotal += s.SuccessTotal
+		merged.ErrorTotal += s.ErrorTotal
+		merged.TicksTimely += s.TicksTimely
+		merged.TicksMissed += s.TicksMissed
+		merged.SendsTimely += s.SendsTimely
+		merged.SendsLate += s.SendsLate
+		requestTime += s.AvgRequestTime * float64(s.SuccessTotal)
+		merged.SuccessHistogram.Merge(s.SuccessHistogram)
+		for text, count := range s.Errors {
+			merged.Errors[text] += count
+		}
+		merged.Timings = mergeHistograms(merged.Timings, s.Timings)
+		merged.Counts = mergeHistograms(merged.Counts, s.Counts)
+		mergeResults(merged, s)
+	}
+
+	merged.AbortReason = strings.Join(abortReasons, "; ")
+	merged.TimeElapsed = end.Sub(merged.Start)
+	if merged.SuccessTotal > 0 {
+		merged.AvgRequestTime = requestTime / float64(merged.SuccessTotal)
+	}
+	merged.computeRatios()
+
+	return merged, nil
+}
+
+// mergeHistograms adds the named histograms of src to dst, which is created
+// as necessary.
+func mergeHistograms(dst, src map[string]*hdrhistogram.Histogram) map[string]*hdrhistogram.Histogram {
+	for name, h := range src {
+		if dst == nil {
+			dst = make(map[string]*hdrhistogram.Histogram)
+		}
+		if dst[name] == nil {
+			dst[name] = hdrhistogram.New(h.LowestTrackableValue(), h.HighestTrackableValue(), int(h.SignificantFigures()))
+		}
+		dst[name].Merge(h)
+	}
+	return dst
+}
@@ -0,0 +1,64 @@
+package bench
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMergeSummaries(t *testing.T) {
+	a := testSummary(t, OpenLoop, 2e6, 4e6)
+	b := testSummary(t, OpenLoop, 8e6, 8e6, 8e6)
+	b.Start = a.Start.Add(5 * time.Second)
+	b.AvgRequestTime = 25
+	b.Aborted, b.StopReason, b.AbortReason = true, StopAborted, "p99 too high"
+
+	merged, err := MergeSummaries(a, b)
+	if err != nil {
+		t.Fatalf("MergeSummaries() error = %v", err)
+	}
+
+	if merged.SuccessTotal != 5 || merged.ErrorTotal != 2 || merged.SuccessHistogram.TotalCount() != 5 {
+		t.Errorf("successes, errors, histogram count = %d, %d, %d, want 5, 2, 5",
+			merged.SuccessTotal, merged.ErrorTotal, merged.SuccessHistogram.TotalCount())
+	}
+	if merged.Connections != 4 || merged.RequestRate != 200 {
+		t.Errorf("Connections, RequestRate = %d, %v, want 4, 200", merged.Connections, merged.RequestRate)
+	}
+	if !merged.Start.Equal(a.Start) || merged.TimeElapsed != 15*time.Second {
+		t.Errorf("Start, TimeElapsed = %v, %v, want %v, 15s", merged.Start, merged.TimeElapsed, a.Start)
+	}
+	// Weighted by the number of successes
+	if want := (12.5*2 + 25*3) / 5; merged.AvgRequestTime != want {
+		t.Errorf("AvgRequestTime = %v, want %v", merged.AvgRequestTime, want)
+	}
+	if !merged.Aborted || merged.StopReason != StopAborted || merged.AbortReason != "p99 too high" {
+		t.Errorf("Aborted, StopReason, AbortReason = %v, %v, %q", merged.Aborted, merged.StopReason, merged.AbortReason)
+	}
+	if merged.Errors["Expected 200 got 503"] != 2 {
+		t.Errorf("Errors = %v, want 2", merged.Errors)
+	}
+	if want := map[int]uint64{200: 5, 503: 2}; !reflect.DeepEqual(merged.StatusCodes, want) {
+		t.Errorf("StatusCodes = %v, want %v", merged.StatusCodes, want)
+	}
+	if merged.Timings["Connect"].TotalCount() != 5 {
+		t.Errorf("Connect timings = %d, want 5", merged.Timings["Connect"].TotalCount())
+	}
+	if want := 5. * 100 / 7; merged.TicksTimelyRatio != want {
+		t.Errorf("TicksTimelyRatio = %v, want %v", merged.TicksTimelyRatio, want)
+	}
+
+	// The inputs are left alone
+	if a.SuccessHistogram.TotalCount() != 2 || a.StatusCodes[200] != 2 {
+		t.Error("MergeSummaries() changed its input")
+	}
+}
+
+func TestMergeSummariesErrors(t *testing.T) {
+	if _, err := MergeSummaries(); err == nil {
+		t.Error("MergeSummaries() of nothing succeeded")
+	}
+	if _, err := MergeSummaries(testSummary(t, OpenLoop, 1e6), testSummary(t, ClosedLoop, 1e6)); err == nil {
+		t.Error("MergeSummaries() of different modes succeeded")
+	}
+}
@@ -0,0 +1,150 @@
+package bench
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+// metricsLatencyBuckets are the upper bounds (in seconds) of the Prometheus
+// latency histogram buckets.
+var metricsLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricsQuantiles are the quantiles exposed by the Prometheus latency summary.
+var metricsQuantiles = []float64{0.5, 0.9, 0.99, 0.999}
+
+// metrics holds the live benchmark state exposed in the Prometheus text
+// format. It is fed by the collector and read by the HTTP handler.
+type metrics struct {
+	mu           sync.Mutex
+	succeeded    uint64
+	failed       map[string]uint64
+	bucketCounts []uint64
+	latencySum   float64
+	histogram    *hdrhistogram.Histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		failed:       make(map[string]uint64),
+		bucketCounts: make([]uint64, len(metricsLatencyBuckets)),
+		histogram:    hdrhistogram.New(minRecordableLatencyNS, maxRecordableLatencyNS, intervalSigFigs),
+	}
+}
+
+// observe records the outcome of a single request.
+func (m *metrics) observe(latency int64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		m.failed[ErrorCategory(err)]++
+		return
+	}
+
+	m.succeeded++
+	seconds := float64(latency) / float64(time.Second)
+	m.latencySum += seconds
+	for i, bound := range metricsLatencyBuckets {
+		if seconds <= bound {
+			m.bucketCounts[i]++
+		}
+	}
+	_ = m.histogram.RecordValue(latency)
+}
+
+// ServeMetrics starts serving the live benchmark state in the Prometheus text
+// format on http://addr/metrics. The server runs in the background and is
+// shut down when the run ends; an error is returned if addr can't be listened
+// on. A later Benchmark serving metrics on the same addr before then takes
+// over the server. It must be called before Run.
+func (b *Benchmark) ServeMetrics(addr string) error {
+	b.metrics = newMetrics()
+
+	srv, err := b.attachServer(addr)
+	if err != nil {
+		b.metrics = nil
+		return err
+	}
+
+	srv.handle("/metrics", func(b *Benchmark, w http.ResponseWriter, r *http.Request) {
+		if b.metrics == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		b.writeMetrics(w)
+	})
+	return nil
+}
+
+func (b *Benchmark) writeMetrics(w http.ResponseWriter) {
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	var (
+		timelySends = atomic.LoadUint64(&b.timelySends)
+		lateSends   = atomic.LoadUint64(&b.lateSends)
+	)
+
+	fmt.Fprintln(out, "# HELP labench_requests_sent_total Requests sent to the system under test.")
+	fmt.Fprintln(out, "# TYPE labench_requests_sent_total counter")
+	fmt.Fprintln(out, "labench_requests_sent_total", timelySends+lateSends)
+
+	fmt.Fprintln(out, "# HELP labench_requests_in_flight Requests sent but not yet completed.")
+	fmt.Fprintln(out, "# TYPE labench_requests_in_flight gauge")
+	fmt.Fprintln(out, "labench_requests_in_flight", atomic.LoadInt64(&b.inFlight))
+
+	fmt.Fprintln(out, "# HELP labench_ticks_total Ticks emitted by the ticker, by whether a client was available to pick them up.")
+	fmt.Fprintln(out, "# TYPE labench_ticks_total counter")
+	fmt.Fprintf(out, "labench_ticks_total{result=\"timely\"} %d\n", atomic.LoadUint64(&b.timelyTicks))
+	fmt.Fprintf(out, "labench_ticks_total{result=\"missed\"} %d\n", atomic.LoadUint64(&b.missedTicks))
+
+	fmt.Fprintln(out, "# HELP labench_sends_total Requests sent, by whether they were sent on time.")
+	fmt.Fprintln(out, "# TYPE labench_sends_total counter")
+	fmt.Fprintf(out, "labench_sends_total{result=\"timely\"} %d\n", timelySends)
+	fmt.Fprintf(out, "labench_sends_total{result=\"late\"} %d\n", lateSends)
+
+	m := b.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(out, "# HELP labench_requests_succeeded_total Requests which completed successfully.")
+	fmt.Fprintln(out, "# TYPE labench_requests_succeeded_total counter")
+	fmt.Fprintln(out, "labench_requests_succeeded_total", m.succeeded)
+
+	fmt.Fprintln(out, "# HELP labench_requests_failed_total Requests which failed, by error category.")
+	fmt.Fprintln(out, "# TYPE labench_requests_failed_total counter")
+	categories := make([]string, 0, len(m.failed))
+	for category := range m.failed {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		fmt.Fprintf(out, "labench_requests_failed_total{category=%q} %d\n", category, m.failed[category])
+	}
+
+	fmt.Fprintln(out, "# HELP labench_request_latency_seconds Latency of successful requests.")
+	fmt.Fprintln(out, "# TYPE labench_request_latency_seconds histogram")
+	for i, bound := range metricsLatencyBuckets {
+		fmt.Fprintf(out, "labench_request_latency_seconds_bucket{le=\"%g\"} %d\n", bound, m.bucketCounts[i])
+	}
+	fmt.Fprintf(out, "labench_request_latency_seconds_bucket{le=\"+Inf\"} %d\n", m.succeeded)
+	fmt.Fprintf(out, "labench_request_latency_seconds_sum %g\n", m.latencySum)
+	fmt.Fprintf(out, "labench_request_latency_seconds_count %d\n", m.succeeded)
+
+	fmt.Fprintln(out, "# HELP labench_request_latency_quantile_seconds Latency quantiles of successful requests since the start of the run.")
+	fmt.Fprintln(out, "# TYPE labench_request_latency_quantile_seconds summary")
+	for _, q := range metricsQuantiles {
+		fmt.Fprintf(out, "labench_request_latency_quantile_seconds{quantile=\"%g\"} %g\n", q,
+			float64(m.histogram.ValueAtQuantile(q*100))/float64(time.Second))
+	}
+	fmt.Fprintf(out, "labench_request_latency_quantile_seconds_sum %g\n", m.latencySum)
+	fmt.Fprintf(out, "labench_request_latency_quantile_seconds_count %d\n", m.succeeded)
+}
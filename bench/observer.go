@@ -0,0 +1,110 @@
+package bench
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Observer is notified of the events of a benchmark run, e.g. to export custom
+// metrics. Its methods are called while the benchmark is running and must
+// return quickly, a slow Observer delays requests and skews the latencies.
+// Embed NopObserver to only implement some of them.
+type Observer interface {
+	// TickEmitted is called by the ticker when a connection took the tick
+	// of a request due at intended.
+	TickEmitted(intended time.Time)
+
+	// TickMissed is called by the ticker when no connection was ready to
+	// take the tick of a request due at intended, it's not sent.
+	TickMissed(intended time.Time)
+
+	// RequestStarted is called by a connection right before it issues a
+	// request. It's called concurrently by all connections. A request which
+	// finds its Requester exhausted (see ErrSourceExhausted) never completes.
+	RequestStarted(client uint64, intended, sent time.Time)
+
+	// RequestCompleted is called by the collector for every completed
+	// request, in the order they were collected.
+	RequestCompleted(result RequestResult)
+
+	// IntervalCompleted is called by the collector at the end of every stats
+	// interval.
+	IntervalCompleted(stats IntervalStats)
+}
+
+// RequestResult is the outcome of a single request, as passed to
+// Observer.RequestCompleted.
+type RequestResult struct {
+	Client   uint64
+	Intended time.Time
+	Sent     time.Time
+
+	// Latency is the latency as recorded, with the BaseLatency subtracted.
+	Latency time.Duration
+
+	// Err is the error returned by the Requester, nil for successes.
+	Err error
+
+	// Details are the details of a DetailedRequester, empty otherwise.
+	Details RequestDetails
+}
+
+// NopObserver implements Observer by ignoring all events.
+type NopObserver struct{}
+
+// TickEmitted implements Observer.
+func (NopObserver) TickEmitted(time.Time) {}
+
+// TickMissed implements Observer.
+func (NopObserver) TickMissed(time.Time) {}
+
+// RequestStarted implements Observer.
+func (NopObserver) RequestStarted(uint64, time.Time, time.Time) {}
+
+// RequestCompleted implements Observer.
+func (NopObserver) RequestCompleted(RequestResult) {}
+
+// IntervalCompleted implements Observer.
+func (NopObserver) IntervalCompleted(IntervalStats) {}
+
+// AddObserver makes the benchmark notify o of the events of the run. It must be
+// called before Run.
+func (b *Benchmark) AddObserver(o Observer) {
+	b.observers = append(b.observers, o)
+}
+
+// tickMissed counts a tick no worker was ready for.
+func (b *Benchmark) tickMissed(tick time.Time) {
+	atomic.AddUint64(&b.missedTicks, 1)
+	for _, o := range b.observers {
+		o.TickMissed(tick)
+	}
+}
+
+// requestCompleted notifies the observers of a collected sample.
+func (b *Benchmark) requestCompleted(s *sample, baseLatency int64) {
+	if len(b.observers) == 0 {
+		return
+	}
+
+	result := RequestResult{
+		Client:   s.client,
+		Intended: s.intended,
+		Sent:     s.sent,
+		Latency:  time.Duration(s.latency - baseLatency),
+		Err:      s.err,
+		Details:  s.details,
+	}
+	for _, o := range b.observers {
+		o.RequestCompleted(result)
+	}
+}
+
+// intervalCompleted records the statistics of a finished interval and
+// notifies the observers.
+func (b *Benchmark) intervalCompleted(stats IntervalStats) {
+	b.intervals = append(b.intervals, stats)
+	for _, o := range b.observers {
+		o.IntervalCompleted(stats)
+	}
+}
@@ -0,0 +1,83 @@
+package bench
+
+import (
+	"errors"
+	"io"
+	"log"
+	"time"
+)
+
+// Options configure a Benchmark created by New. Factory is required, the zero
+// value of every other field is a sensible default.
+type Options struct {
+	// Factory creates the Requesters, one per connection.
+	Factory RequesterFactory
+
+	// RequestRate is the number of requests per second to issue, divided
+	// across the connections. Zero runs a ClosedLoop benchmark, where each
+	// connection issues requests back-to-back.
+	RequestRate uint64
+
+	// Connections is the number of concurrent Requesters, defaults to 1.
+	Connections uint64
+
+	// Duration is how long to run the benchmark, zero runs it until another
+	// termination condition is met (see MaxRequests and ErrSourceExhausted).
+	// Without Duration and MaxRequests a run whose Requesters never return
+	// ErrSourceExhausted doesn't end until Stop is called or the context of
+	// RunContext is done, which should carry a deadline then.
+	Duration time.Duration
+
+	// MaxRequests stops the benchmark once that many requests were sent,
+	// zero doesn't limit the number of requests.
+	MaxRequests uint64
+
+	// BaseLatency is subtracted from every latency measurement.
+	BaseLatency time.Duration
+
+	// ThinkTime is how long each connection of a ClosedLoop benchmark waits
+	// after a request completes before issuing the next one.
+	ThinkTime time.Duration
+
+	// StartAt, if set, is the wall-clock time the run starts at, e.g. to
+	// start several benchmarks in sync. The Requesters are set up before
+	// waiting for it. A time in the past starts the run right away.
+	StartAt time.Time
+
+	// Abort stops the benchmark early when one of the conditions is met.
+	Abort *AbortConditions
+
+	// Observers are notified of the events of the run.
+	Observers []Observer
+
+	// TightTicker busy-waits for every tick instead of sleeping, which is
+	// more precise but keeps a CPU core busy. It's used anyway when the OS
+	// timer resolution is too coarse for the request rate.
+	TightTicker bool
+
+	// OutputJSON makes Summary.String include the Summary as JSON.
+	OutputJSON bool
+
+	// Output receives the progress messages of a run, e.g. the ticker used
+	// and the errors seen, nil discards them.
+	Output io.Writer
+
+	// Logger receives problems which don't fail the run, e.g. failed
+	// Teardowns, nil discards them.
+	Logger *log.Logger
+}
+
+// validate returns the first invalid option.
+func (o *Options) validate() error {
+	switch {
+	case o.Factory == nil:
+		return errors.New("Factory must be set")
+	case o.Duration < 0:
+		return errors.New("Duration must not be negative")
+	case o.BaseLatency < 0:
+		return errors.New("BaseLatency must not be negative")
+	case o.ThinkTime < 0:
+		return errors.New("ThinkTime must not be negative")
+	}
+	return nil
+}
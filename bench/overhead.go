@@ -0,0 +1,241 @@
+package bench
+
+import (
+	"container/heap"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	runtimemetrics "runtime/metrics"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+const (
+	// overheadSampleInterval is how often the runtime stats are sampled.
+	// They're read without stopping the world, unlike runtime.MemStats,
+	// so sampling doesn't pause the generator itself.
+	overheadSampleInterval = 100 * time.Millisecond
+
+	// heapObjectsMetric is the runtime metric of the heap in use, the
+	// same as runtime.MemStats.HeapAlloc.
+	heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+	// schedDelayThreshold is the scheduling delay, well beyond the usual
+	// timer slack, from which on the generator is considered paused.
+	schedDelayThreshold = 5 * time.Millisecond
+
+	// slowestRequests is the number of slowest requests checked for
+	// overlaps with generator pauses.
+	slowestRequests = 100
+
+	// cpuSaturationWarning is the CPU utilization, in percent of all cores,
+	// from which on the generator is considered saturated.
+	cpuSaturationWarning = 90
+)
+
+// OverheadStats describes the health of the load generator itself during a
+// run, since its GC pauses, scheduling delays and CPU saturation show up as
+// latency of the system under test.
+type OverheadStats struct {
+	GCCount       uint32
+	GCPauseTotal  time.Duration
+	GCPauseP99    time.Duration
+	GCPauseMax    time.Duration
+	MaxGoroutines int
+	MaxHeapAlloc  uint64
+
+	// SchedDelay is how much later than requested the sampling goroutine
+	// woke up, a measure of how long goroutines wait to be scheduled.
+	SchedDelayP99 time.Duration
+	SchedDelayMax time.Duration
+
+	// CPUTime is the user and system CPU time of the process, CPUUtilization
+	// the percentage of all cores it used.
+	CPUTime        time.Duration
+	CPUUtilization float64
+
+	// PausedOutliers is how many of the SlowestChecked slowest requests were
+	// in flight during a GC pause or scheduling delay of the generator.
+	SlowestChecked int
+	PausedOutliers int
+
+	Warnings []string
+}
+
+// pauseWindow is a time span in which the generator was paused.
+type pauseWindow struct {
+	start, end time.Time
+}
+
+// overheadMonitor samples the runtime stats in the background and keeps the
+// slowest requests, which are fed by the collector.
+type overheadMonitor struct {
+	stopCh     chan struct{}
+	doneCh     chan struct{}
+	start      time.Time
+	startCPU   time.Duration
+	gcPauses   *hdrhistogram.Histogram
+	schedDelay *hdrhistogram.Histogram
+	pauses     []pauseWindow
+	slowest    requestHeap
+	stats      OverheadStats
+}
+
+func newOverheadMonitor() *overheadMonitor {
+	return &overheadMonitor{
+		stopCh:     make(chan struct{}),
+		doneCh:     make(chan struct{}),
+		gcPauses:   hdrhistogram.New(1, maxRecordableLatencyNS, intervalSigFigs),
+		schedDelay: hdrhistogram.New(1, maxRecordableLatencyNS, intervalSigFigs),
+	}
+}
+
+func (m *overheadMonitor) run() {
+	defer close(m.doneCh)
+
+	var (
+		gcStats debug.GCStats
+		heap    = []runtimemetrics.Sample{{Name: heapObjectsMetric}}
+	)
+	debug.ReadGCStats(&gcStats)
+	lastGC := gcStats.NumGC
+
+	m.start = time.Now()
+	m.startCPU = processCPUTime()
+
+	for {
+		requested := time.Now().Add(overheadSampleInterval)
+		select {
+		case <-m.stopCh:
+			return
+		case <-time.After(overheadSampleInterval):
+		}
+
+		now := time.Now()
+		delay := now.Sub(requested)
+		if delay < 0 {
+			delay = 0
+		}
+		_ = m.schedDelay.RecordValue(delay.Nanoseconds())
+		if delay >= schedDelayThreshold {
+			m.pauses = append(m.pauses, pauseWindow{requested, now})
+		}
+
+		if n := runtime.NumGoroutine(); n > m.stats.MaxGoroutines {
+			m.stats.MaxGoroutines = n
+		}
+
+		runtimemetrics.Read(heap)
+		if heap[0].Value.Kind() == runtimemetrics.KindUint64 && heap[0].Value.Uint64() > m.stats.MaxHeapAlloc {
+			m.stats.MaxHeapAlloc = heap[0].Value.Uint64()
+		}
+
+		// Pauses are listed most recent first, only the last 256 are kept
+		// by the runtime
+		debug.ReadGCStats(&gcStats)
+		newGCs := int(gcStats.NumGC - lastGC)
+		if newGCs > len(gcStats.Pause) {
+			newGCs = len(gcStats.Pause)
+		}
+		for i := 0; i < newGCs; i++ {
+			pause, end := gcStats.Pause[i], gcStats.PauseEnd[i]
+			_ = m.gcPauses.RecordValue(pause.Nanoseconds())
+			m.stats.GCPauseTotal += pause
+			m.pauses = append(m.pauses, pauseWindow{end.Add(-pause), end})
+		}
+		m.stats.GCCount += uint32(gcStats.NumGC - lastGC)
+		lastGC = gcStats.NumGC
+	}
+}
+
+// observe keeps track of the slowest requests, it's called by the collector.
+func (m *overheadMonitor) observe(s sample) {
+	if len(m.slowest) < slowestRequests {
+		heap.Push(&m.slowest, s)
+	} else if s.latency > m.slowest[0].latency {
+		m.slowest[0] = s
+		heap.Fix(&m.slowest, 0)
+	}
+}
+
+// stop ends the sampling and returns the stats of the run.
+func (m *overheadMonitor) stop() *OverheadStats {
+	close(m.stopCh)
+	<-m.doneCh
+
+	stats := m.stats
+	stats.GCPauseP99 = time.Duration(m.gcPauses.ValueAtQuantile(99))
+	stats.GCPauseMax = time.Duration(m.gcPauses.Max())
+	stats.SchedDelayP99 = time.Duration(m.schedDelay.ValueAtQuantile(99))
+	stats.SchedDelayMax = time.Duration(m.schedDelay.Max())
+
+	elapsed := time.Since(m.start)
+	stats.CPUTime = processCPUTime() - m.startCPU
+	if elapsed > 0 {
+		stats.CPUUtilization = float64(stats.CPUTime) * 100 / float64(elapsed) / float64(runtime.NumCPU())
+	}
+
+	// Overlaps of the slowest requests with generator pauses
+	sort.Slice(m.pauses, func(i, j int) bool { return m.pauses[i].start.Before(m.pauses[j].start) })
+	stats.SlowestChecked = len(m.slowest)
+	for _, s := range m.slowest {
+		end := s.sent.Add(time.Duration(s.latency))
+		i := sort.Search(len(m.pauses), func(i int) bool { return !m.pauses[i].start.Before(end) })
+		for j := i - 1; j >= 0; j-- {
+			if m.pauses[j].end.After(s.sent) {
+				stats.PausedOutliers++
+				break
+			}
+			// Pauses are short, earlier ones can't overlap anymore
+			if s.sent.Sub(m.pauses[j].end) > time.Second {
+				break
+			}
+		}
+	}
+
+	if stats.PausedOutliers > 0 {
+		stats.Warnings = append(stats.Warnings, fmt.Sprintf(
+			"%d of the %d slowest requests were in flight during a GC pause or scheduling delay of LaBench, the latency tail may be inflated by the generator itself",
+			stats.PausedOutliers, stats.SlowestChecked))
+	}
+	if stats.CPUUtilization >= cpuSaturationWarning {
+		stats.Warnings = append(stats.Warnings, fmt.Sprintf(
+			"LaBench used %.0f%% of all CPU cores, the generator was probably saturated", stats.CPUUtilization))
+	}
+
+	return &stats
+}
+
+// rows returns the stats as name and value pairs for the summary tables.
+func (o *OverheadStats) rows() [][]string {
+	return [][]string{
+		{"GC Pauses", strconv.FormatUint(uint64(o.GCCount), 10)},
+		{"GC Pause Total (ms)", strconv.FormatFloat(durationMS(o.GCPauseTotal), 'f', 2, 64)},
+		{"GC Pause p99 (ms)", strconv.FormatFloat(durationMS(o.GCPauseP99), 'f', 2, 64)},
+		{"GC Pause Max (ms)", strconv.FormatFloat(durationMS(o.GCPauseMax), 'f', 2, 64)},
+		{"Max Goroutines", strconv.Itoa(o.MaxGoroutines)},
+		{"Max Heap (MiB)", strconv.FormatFloat(float64(o.MaxHeapAlloc)/(1<<20), 'f', 2, 64)},
+		{"Scheduling Delay p99 (ms)", strconv.FormatFloat(durationMS(o.SchedDelayP99), 'f', 2, 64)},
+		{"Scheduling Delay Max (ms)", strconv.FormatFloat(durationMS(o.SchedDelayMax), 'f', 2, 64)},
+		{"CPU Time (sec)", strconv.FormatFloat(o.CPUTime.Seconds(), 'f', 2, 64)},
+		{"CPU Utilization %", strconv.FormatFloat(o.CPUUtilization, 'f', 2, 64)},
+		{"Slowest Requests During Pauses", fmt.Sprintf("%d of %d", o.PausedOutliers, o.SlowestChecked)},
+	}
+}
+
+// requestHeap is a min-heap of samples by latency.
+type requestHeap []sample
+
+func (h requestHeap) Len() int            { return len(h) }
+func (h requestHeap) Less(i, j int) bool  { return h[i].latency < h[j].latency }
+func (h requestHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *requestHeap) Push(x interface{}) { *h = append(*h, x.(sample)) }
+func (h *requestHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,38 @@
+package bench
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestOverheadMonitor(t *testing.T) {
+	m := newOverheadMonitor()
+	go m.run()
+
+	// A request in flight during the forced GCs, and one long after them
+	sent := time.Now()
+	time.Sleep(overheadSampleInterval / 2)
+	runtime.GC()
+	runtime.GC()
+	m.observe(sample{sent: sent, latency: time.Since(sent).Nanoseconds()})
+	time.Sleep(2 * overheadSampleInterval)
+	m.observe(sample{sent: time.Now().Add(time.Hour), latency: 1})
+
+	stats := m.stop()
+	if stats.GCCount < 2 {
+		t.Errorf("GCCount = %d, want at least 2", stats.GCCount)
+	}
+	if stats.GCPauseTotal <= 0 || stats.GCPauseMax <= 0 {
+		t.Errorf("GCPauseTotal, GCPauseMax = %v, %v, want positive", stats.GCPauseTotal, stats.GCPauseMax)
+	}
+	if stats.MaxHeapAlloc == 0 {
+		t.Error("MaxHeapAlloc = 0")
+	}
+	if stats.MaxGoroutines == 0 {
+		t.Error("MaxGoroutines = 0")
+	}
+	if stats.SlowestChecked != 2 || stats.PausedOutliers != 1 {
+		t.Errorf("PausedOutliers = %d of %d, want 1 of 2", stats.PausedOutliers, stats.SlowestChecked)
+	}
+}
@@ -0,0 +1,455 @@
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// RawLogFormat is the file format of the raw request log.
+type RawLogFormat string
+
+const (
+	// RawLogCSV writes one comma separated line per request, with a header.
+	RawLogCSV RawLogFormat = "csv"
+
+	// RawLogJSONL writes one compact JSON object per line per request.
+	RawLogJSONL RawLogFormat = "jsonl"
+
+	// RawLogBinary writes compact varint encoded records, times are delta
+	// encoded and repeated strings (targets, errors) are written only once.
+	RawLogBinary RawLogFormat = "bin"
+)
+
+// rawLogBinaryMagic starts every binary raw log.
+const rawLogBinaryMagic = "LABENCH-RAW1\n"
+
+// rawLogCSVModePrefix starts the comment line with the Mode of the
+// Benchmark, which precedes the header of a CSV raw log.
+const rawLogCSVModePrefix = "# mode: "
+
+const (
+	rawLogBufferSize  = 1 << 20
+	rawLogQueueLength = 1 << 16
+)
+
+// noStatusCode is the status code logged for requests without one.
+const noStatusCode = -1
+
+var rawLogCSVHeader = []string{"intended", "sent", "latency", "client", "target", "status", "error", "bytes", "message"}
+
+// RequestLogHeader describes the benchmark run which wrote a raw request
+// log, it precedes the records.
+type RequestLogHeader struct {
+	Mode Mode `json:"mode"`
+}
+
+// RequestRecord is a single entry of the raw request log. Times are in
+// nanoseconds since the Unix epoch, latency is in nanoseconds. StatusCode is
+// -1 for requests without a status code. Error is the ErrorCategory of a
+// failed request, Message the error itself, as counted in Summary.Errors.
+type RequestRecord struct {
+	Intended      int64  `json:"intended"`
+	Sent          int64  `json:"sent"`
+	Latency       int64  `json:"latency"`
+	Client        uint64 `json:"client"`
+	Target        string `json:"target,omitempty"`
+	StatusCode    int    `json:"status"`
+	Error         string `json:"error,omitempty"`
+	BytesReceived int64  `json:"bytes"`
+	Message       string `json:"message,omitempty"`
+}
+
+func newRequestRecord(s *sample) RequestRecord {
+	statusCode := noStatusCode
+	if s.details.HasStatus {
+		statusCode = s.details.StatusCode
+	}
+	var message string
+	if s.err != nil {
+		message = s.err.Error()
+	}
+	return RequestRecord{
+		Intended:      s.intended.UnixNano(),
+		Sent:          s.sent.UnixNano(),
+		Latency:       s.latency,
+		Client:        s.client,
+		Target:        s.details.Target,
+		StatusCode:    statusCode,
+		Error:         ErrorCategory(s.err),
+		BytesReceived: s.details.BytesReceived,
+		Message:       message,
+	}
+}
+
+// rawLog writes every request to a file. Records are queued by the
+// collector and written by a dedicated goroutine through a large buffer, so
+// logging doesn't perturb the measurement.
+type rawLog struct {
+	file    *os.File
+	writer  *bufio.Writer
+	encode  func(r *RequestRecord) error
+	flush   func() error
+	records chan RequestRecord
+	done    chan error
+}
+
+// LogRequests makes the benchmark record every request to the given file in
+// the given format, after a RequestLogHeader. It must be called before Run;
+// the file is closed when Run returns.
+func (b *Benchmark) LogRequests(file string, format RawLogFormat) error {
+	if format == "" {
+		format = RawLogCSV
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	l := &rawLog{
+		file:    f,
+		writer:  bufio.NewWriterSize(f, rawLogBufferSize),
+		records: make(chan RequestRecord, rawLogQueueLength),
+		done:    make(chan error, 1),
+	}
+
+	switch format {
+	case RawLogCSV:
+		if _, err := l.writer.WriteString(rawLogCSVModePrefix + string(b.mode) + "\n"); err != nil {
+			_ = f.Close()
+			return err
+		}
+		w := csv.NewWriter(l.writer)
+		if err := w.Write(rawLogCSVHeader); err != nil {
+			_ = f.Close()
+			return err
+		}
+		row := make([]string, len(rawLogCSVHeader))
+		l.encode = func(r *RequestRecord) error {
+			row[0] = strconv.FormatInt(r.Intended, 10)
+			row[1] = strconv.FormatInt(r.Sent, 10)
+			row[2] = strconv.FormatInt(r.Latency, 10)
+			row[3] = strconv.FormatUint(r.Client, 10)
+			row[4] = r.Target
+			row[5] = strconv.Itoa(r.StatusCode)
+			row[6] = r.Error
+			row[7] = strconv.FormatInt(r.BytesReceived, 10)
+			row[8] = r.Message
+			return w.Write(row)
+		}
+		l.flush = func() error {
+			w.Flush()
+			return w.Error()
+		}
+
+	case RawLogJSONL:
+		enc := json.NewEncoder(l.writer)
+		if err := enc.Encode(RequestLogHeader{Mode: b.mode}); err != nil {
+			_ = f.Close()
+			return err
+		}
+		l.encode = func(r *RequestRecord) error { return enc.Encode(r) }
+		l.flush = func() error { return nil }
+
+	case RawLogBinary:
+		if _, err := l.writer.WriteString(rawLogBinaryMagic); err != nil {
+			_ = f.Close()
+			return err
+		}
+		enc := newBinaryRecordEncoder(l.writer)
+		enc.str(string(b.mode))
+		l.encode = enc.encode
+		l.flush = func() error { return nil }
+
+	default:
+		_ = f.Close()
+		return fmt.Errorf("unknown raw log format %q", format)
+	}
+
+	b.rawLog = l
+	return nil
+}
+
+// start launches the writer goroutine.
+func (l *rawLog) start() {
+	go func() {
+		var err error
+		for r := range l.records {
+			r := r
+			if err == nil {
+				err = l.encode(&r)
+			}
+		}
+		if err == nil {
+			err = l.flush()
+		}
+		if err == nil {
+			err = l.writer.Flush()
+		}
+		if closeErr := l.file.Close(); err == nil {
+			err = closeErr
+		}
+		l.done <- err
+	}()
+}
+
+// log queues a request record for writing.
+func (l *rawLog) log(s sample) {
+	l.records <- newRequestRecord(&s)
+}
+
+// close waits for all queued records to be written and closes the file.
+func (l *rawLog) close() error {
+	close(l.records)
+	return <-l.done
+}
+
+// binaryRecordEncoder writes records in the RawLogBinary format.
+type binaryRecordEncoder struct {
+	w            *bufio.Writer
+	buf          [binary.MaxVarintLen64]byte
+	prevIntended int64
+	strings      map[string]uint64
+}
+
+func newBinaryRecordEncoder(w *bufio.Writer) *binaryRecordEncoder {
+	return &binaryRecordEncoder{w: w, strings: map[string]uint64{}}
+}
+
+func (e *binaryRecordEncoder) varint(v int64) {
+	n := binary.PutVarint(e.buf[:], v)
+	_, _ = e.w.Write(e.buf[:n])
+}
+
+func (e *binaryRecordEncoder) uvarint(v uint64) {
+	n := binary.PutUvarint(e.buf[:], v)
+	_, _ = e.w.Write(e.buf[:n])
+}
+
+// str writes a reference to the string table, a string which isn't in the
+// table yet is written inline right after its new index.
+func (e *binaryRecordEncoder) str(s string) {
+	if idx, ok := e.strings[s]; ok {
+		e.uvarint(idx)
+		return
+	}
+	idx := uint64(len(e.strings))
+	e.strings[s] = idx
+	e.uvarint(idx)
+	e.uvarint(uint64(len(s)))
+	_, _ = e.w.WriteString(s)
+}
+
+func (e *binaryRecordEncoder) encode(r *RequestRecord) error {
+	e.varint(r.Intended - e.prevIntended)
+	e.prevIntended = r.Intended
+	e.varint(r.Sent - r.Intended)
+	e.varint(r.Latency)
+	e.uvarint(r.Client)
+	e.varint(int64(r.StatusCode))
+	e.varint(r.BytesReceived)
+	e.str(r.Target)
+	e.str(r.Error)
+	e.str(r.Message)
+
+	// bufio.Writer keeps the first error, so it's enough to check it once
+	_, err := e.w.Write(nil)
+	return err
+}
+
+// binaryRecordDecoder reads records in the RawLogBinary format.
+type binaryRecordDecoder struct {
+	r            *bufio.Reader
+	prevIntended int64
+	strings      []string
+}
+
+func (d *binaryRecordDecoder) str() (string, error) {
+	idx, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return "", err
+	}
+	if idx < uint64(len(d.strings)) {
+		return d.strings[idx], nil
+	}
+	if idx != uint64(len(d.strings)) {
+		return "", fmt.Errorf("invalid string reference %d", idx)
+	}
+	length, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return "", err
+	}
+	d.strings = append(d.strings, string(buf))
+	return string(buf), nil
+}
+
+func (d *binaryRecordDecoder) decode(r *RequestRecord) error {
+	delta, err := binary.ReadVarint(d.r)
+	if err != nil {
+		// a clean EOF is only possible at a record boundary
+		return err
+	}
+
+	varint := func() int64 {
+		var v int64
+		if err == nil {
+			v, err = binary.ReadVarint(d.r)
+		}
+		return v
+	}
+	str := func() string {
+		var v string
+		if err == nil {
+			v, err = d.str()
+		}
+		return v
+	}
+
+	d.prevIntended += delta
+	r.Intended = d.prevIntended
+	r.Sent = r.Intended + varint()
+	r.Latency = varint()
+	if err == nil {
+		r.Client, err = binary.ReadUvarint(d.r)
+	}
+	r.StatusCode = int(varint())
+	r.BytesReceived = varint()
+	r.Target = str()
+	r.Error = str()
+	r.Message = str()
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ReadRequestLog reads a raw request log written in any of the RawLogFormats
+// and calls fn for every record, in the order they were written. The format
+// is detected from the file contents. It returns the header of the log.
+func ReadRequestLog(file string, fn func(r *RequestRecord) error) (*RequestLogHeader, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	in := bufio.NewReaderSize(f, rawLogBufferSize)
+	head, err := in.Peek(len(rawLogBinaryMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var (
+		header RequestLogHeader
+		record RequestRecord
+	)
+	switch {
+	case bytes.Equal(head, []byte(rawLogBinaryMagic)):
+		_, _ = in.Discard(len(rawLogBinaryMagic))
+		dec := &binaryRecordDecoder{r: in}
+		mode, err := dec.str()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		header.Mode = Mode(mode)
+		for {
+			if err := dec.decode(&record); err == io.EOF {
+				return &header, nil
+			} else if err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			if err := fn(&record); err != nil {
+				return nil, err
+			}
+		}
+
+	case bytes.HasPrefix(head, []byte("{")):
+		dec := json.NewDecoder(in)
+		if err := dec.Decode(&header); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		for {
+			record = RequestRecord{}
+			if err := dec.Decode(&record); err == io.EOF {
+				return &header, nil
+			} else if err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			if err := fn(&record); err != nil {
+				return nil, err
+			}
+		}
+
+	case bytes.HasPrefix(head, []byte(rawLogCSVModePrefix)):
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		header.Mode = Mode(strings.TrimSpace(strings.TrimPrefix(line, rawLogCSVModePrefix)))
+
+		r := csv.NewReader(in)
+		r.FieldsPerRecord = len(rawLogCSVHeader)
+		r.ReuseRecord = true
+		if _, err := r.Read(); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		for {
+			row, err := r.Read()
+			if err == io.EOF {
+				return &header, nil
+			}
+			if err == nil {
+				err = parseCSVRecord(row, &record)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			if err := fn(&record); err != nil {
+				return nil, err
+			}
+		}
+
+	case len(head) == 0:
+		return &header, nil
+
+	default:
+		return nil, fmt.Errorf("%s: unknown raw log format", file)
+	}
+}
+
+func parseCSVRecord(row []string, r *RequestRecord) (err error) {
+	parseInt := func(s string) int64 {
+		v, e := strconv.ParseInt(s, 10, 64)
+		if e != nil && err == nil {
+			err = e
+		}
+		return v
+	}
+
+	r.Intended = parseInt(row[0])
+	r.Sent = parseInt(row[1])
+	r.Latency = parseInt(row[2])
+	r.Client = uint64(parseInt(row[3]))
+	r.Target = row[4]
+	r.StatusCode = int(parseInt(row[5]))
+	r.Error = row[6]
+	r.BytesReceived = parseInt(row[7])
+	r.Message = row[8]
+	return err
+}
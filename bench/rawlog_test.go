@@ -0,0 +1,128 @@
+package bench
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testRecords = []RequestRecord{
+	{Intended: 1600000000000000000, Sent: 1600000000000100000, Latency: 12345678, Client: 0, Target: "http://localhost/a", StatusCode: 200, BytesReceived: 512},
+	{Intended: 1600000000010000000, Sent: 1600000000010000000, Latency: 1, Client: 7, Target: "http://localhost/a", StatusCode: 503, Error: "status_503", Message: "Expected 200 got 503"},
+	// Completion order, so intended times aren't monotonic
+	{Intended: 1599999999990000000, Sent: 1600000000000000000, Latency: 0, Client: 1, Target: "q,\"uoted\"\ntarget", StatusCode: -1, Error: "connection", BytesReceived: -1, Message: "dial tcp: connection refused"},
+	{Intended: 1600000000020000000, Sent: 1600000000020000000, Latency: 99999999999, Client: 1 << 40, StatusCode: 0, Error: "status_0", Message: "Expected 0 (OK) got 14 (Unavailable)"},
+	{Intended: 1600000000030000000, Sent: 1600000000030000000, Latency: 5, Client: 7, Target: "http://localhost/a", Error: "connection", Message: "dial tcp: connection refused"},
+}
+
+func readRecords(t *testing.T, file string) (*RequestLogHeader, []RequestRecord) {
+	t.Helper()
+
+	var records []RequestRecord
+	header, err := ReadRequestLog(file, func(r *RequestRecord) error {
+		records = append(records, *r)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadRequestLog() error = %v", err)
+	}
+	return header, records
+}
+
+func TestRequestLogRoundTrip(t *testing.T) {
+	for _, format := range []RawLogFormat{RawLogCSV, RawLogJSONL, RawLogBinary} {
+		for _, mode := range []Mode{OpenLoop, ClosedLoop} {
+			t.Run(string(format)+"/"+string(mode), func(t *testing.T) {
+				file := writeRequestLog(t, format, mode, testRecords)
+				header, got := readRecords(t, file)
+				if header.Mode != mode {
+					t.Errorf("header mode = %q, want %q", header.Mode, mode)
+				}
+				if !reflect.DeepEqual(got, testRecords) {
+					t.Errorf("records read = %+v\nwant %+v", got, testRecords)
+				}
+			})
+		}
+	}
+}
+
+func TestRequestLogEmpty(t *testing.T) {
+	for _, format := range []RawLogFormat{RawLogCSV, RawLogJSONL, RawLogBinary} {
+		t.Run(string(format), func(t *testing.T) {
+			file := writeRequestLog(t, format, OpenLoop, nil)
+			if _, got := readRecords(t, file); len(got) != 0 {
+				t.Errorf("records read = %+v, want none", got)
+			}
+		})
+	}
+
+	file := filepath.Join(t.TempDir(), "empty")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, got := readRecords(t, file); len(got) != 0 {
+		t.Errorf("records read from an empty file = %+v, want none", got)
+	}
+}
+
+func TestRequestLogBinaryIsCompact(t *testing.T) {
+	csv := writeRequestLog(t, RawLogCSV, OpenLoop, testRecords)
+	bin := writeRequestLog(t, RawLogBinary, OpenLoop, testRecords)
+	csvInfo, err := os.Stat(csv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binInfo, err := os.Stat(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binInfo.Size() >= csvInfo.Size()/2 {
+		t.Errorf("binary log is %d bytes, the CSV log %d", binInfo.Size(), csvInfo.Size())
+	}
+}
+
+func TestRequestLogInvalid(t *testing.T) {
+	bin, err := ioutil.ReadFile(writeRequestLog(t, RawLogBinary, OpenLoop, testRecords))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"truncated binary", string(bin[:len(bin)-3]), "unexpected EOF"},
+		{"binary header", rawLogBinaryMagic, "unexpected EOF"},
+		{"invalid string reference", rawLogBinaryMagic + "\x00\x00" + "\x02\x00\x00\x00\x00\x00\x05", "invalid string reference 5"},
+		{"short CSV row", "# mode: open-loop\nintended,sent,latency,client,target,status,error,bytes,message\n1,2,3\n", "wrong number of fields"},
+		{"CSV number", "# mode: open-loop\nintended,sent,latency,client,target,status,error,bytes,message\n1,2,x,0,,200,,0,\n", "invalid syntax"},
+		{"CSV header", "intended,sent,latency,client,target,status,error,bytes,message\n", "unknown raw log format"},
+		{"JSON header", "{\"mode\": 1}\n", "cannot unmarshal"},
+		{"JSON", "{\"mode\": \"open-loop\"}\n{\"intended\": \"x\"}\n", "cannot unmarshal"},
+		{"unknown", "hello\n", "unknown raw log format"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "requests")
+			if err := ioutil.WriteFile(file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := ReadRequestLog(file, func(*RequestRecord) error { return nil })
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ReadRequestLog() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLogRequestsUnknownFormat(t *testing.T) {
+	b := &Benchmark{}
+	if err := b.LogRequests(filepath.Join(t.TempDir(), "requests"), "xml"); err == nil {
+		t.Error("LogRequests() with an unknown format succeeded")
+	}
+}
@@ -0,0 +1,181 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+// RequestLogFilter selects the records of a raw request log which are
+// included in a Summary rebuilt by SummarizeRequestLog.
+type RequestLogFilter struct {
+	// From and To select a window of intended send times, relative to the
+	// first intended send time in the log. A zero To means until the end of
+	// the log.
+	From time.Duration
+	To   time.Duration
+
+	// Target, if not empty, only includes requests sent to this target.
+	Target string
+
+	// BaseLatency is subtracted from every latency measurement, the same way
+	// NewBenchmark does it.
+	BaseLatency time.Duration
+}
+
+func (f *RequestLogFilter) match(r *RequestRecord, start int64) bool {
+	offset := time.Duration(r.Intended - start)
+	if offset < f.From || (f.To > 0 && offset >= f.To) {
+		return false
+	}
+	return f.Target == "" || r.Target == f.Target
+}
+
+// SummarizeRequestLog rebuilds the Summary of a benchmark run, including the
+// latency histogram and per interval statistics, from a raw request log
+// written by Benchmark.LogRequests. Ticks aren't recorded in the log, so the
+// tick statistics of the returned Summary are not available (NaN). An error
+// is returned if no request matches the filter.
+func SummarizeRequestLog(file string, filter RequestLogFilter) (*Summary, error) {
+	// The first pass finds the start of the run, which filter windows are
+	// relative to. Records are logged in completion order, so the first
+	// record isn't necessarily the first one sent.
+	start := int64(math.MaxInt64)
+	header, err := ReadRequestLog(file, func(r *RequestRecord) error {
+		if r.Intended < start {
+			start = r.Intended
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if start == math.MaxInt64 {
+		return nil, fmt.Errorf("%s: no requests logged", file)
+	}
+
+	var (
+		baseLatency      = filter.BaseLatency.Nanoseconds()
+		successHistogram = hdrhistogram.New(minRecordableLatencyNS, maxRecordableLatencyNS, sigFigs)
+		lateness         = hdrhistogram.New(1, maxRecordableLatencyNS, intervalSigFigs)
+		clients          = make(map[uint64]struct{})
+		errors           = make(map[string]int)
+		results          = newResultTotals()
+		successTotal     uint64
+		errorTotal       uint64
+		avgRequestTime   float64
+		firstIntended    = int64(math.MaxInt64)
+		lastIntended     = int64(math.MinInt64)
+		lastCompleted    = int64(math.MinInt64)
+		intervals        []IntervalStats
+		interval         = newIntervalCollector(time.Unix(0, start))
+		intervalIdx      = int64(-1)
+	)
+
+	_, err = ReadRequestLog(file, func(r *RequestRecord) error {
+		if !filter.match(r, start) {
+			return nil
+		}
+
+		if r.Intended < firstIntended {
+			firstIntended = r.Intended
+		}
+		if r.Intended > lastIntended {
+			lastIntended = r.Intended
+		}
+		if r.Sent+r.Latency > lastCompleted {
+			lastCompleted = r.Sent + r.Latency
+		}
+		clients[r.Client] = struct{}{}
+		_ = lateness.RecordValue(r.Sent - r.Intended)
+		results.add(&RequestDetails{StatusCode: r.StatusCode, HasStatus: r.StatusCode != noStatusCode, BytesReceived: r.BytesReceived})
+
+		// Requests are attributed to the interval in which they completed,
+		// the same as the collector does it during the run.
+		idx := (r.Sent + r.Latency - start) / int64(defaultStatsInterval)
+		if intervalIdx < 0 {
+			intervalIdx = idx
+			interval.start = time.Unix(0, start).Add(time.Duration(idx) * defaultStatsInterval)
+		}
+		for ; idx > intervalIdx; intervalIdx++ {
+			intervals = append(intervals, interval.flush(interval.start.Add(defaultStatsInterval)))
+		}
+
+		if r.Error != "" {
+			// Keyed by the error itself like the Summary of the run, logs
+			// without messages only have the category
+			message := r.Message
+			if message == "" {
+				message = r.Error
+			}
+			errorTotal++
+			errors[message]++
+			interval.errorTotal++
+			return nil
+		}
+
+		successTotal++
+		recordValue(successHistogram, r.Latency-baseLatency)
+		avgRequestTime = (avgRequestTime*float64(successTotal-1) + float64(r.Latency/1e6)) / float64(successTotal)
+		interval.record(r.Latency - baseLatency)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	requestTotal := successTotal + errorTotal
+	if requestTotal == 0 {
+		return nil, fmt.Errorf("%s: no requests match", file)
+	}
+	intervals = append(intervals, interval.flush(interval.start.Add(defaultStatsInterval)))
+
+	mode := header.Mode
+	if mode == "" {
+		mode = OpenLoop
+	}
+
+	// Without ticks the expected interval, and so the rate, of an OpenLoop
+	// run is derived from the intended send times of the selected requests.
+	// Otherwise the run lasted until the last request completed.
+	var (
+		elapsed          = time.Duration(lastCompleted - firstIntended)
+		expectedInterval time.Duration
+		requestRate      float64
+		timelySends      = requestTotal
+	)
+	if mode == OpenLoop && requestTotal > 1 && lastIntended > firstIntended {
+		expectedInterval = time.Duration(lastIntended-firstIntended) / time.Duration(requestTotal-1)
+		elapsed = time.Duration(lastIntended-firstIntended) + expectedInterval
+		requestRate = float64(time.Second) / float64(expectedInterval)
+
+		timelySends = 0
+		for _, bar := range lateness.Distribution() {
+			if bar.To < expectedInterval.Nanoseconds() {
+				timelySends += uint64(bar.Count)
+			}
+		}
+	}
+
+	summary := &Summary{
+		Mode:             mode,
+		SuccessTotal:     successTotal,
+		ErrorTotal:       errorTotal,
+		TimeElapsed:      elapsed,
+		SuccessHistogram: successHistogram,
+		AvgRequestTime:   avgRequestTime,
+		RequestRate:      requestRate,
+		Start:            time.Unix(0, firstIntended),
+		Connections:      uint64(len(clients)),
+		Errors:           formatErrors(errors),
+		SendsTimely:      timelySends,
+		SendsLate:        requestTotal - timelySends,
+		Intervals:        intervals,
+	}
+	results.summarize(summary)
+	summary.computeRatios()
+
+	return summary, nil
+}
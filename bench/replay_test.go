@@ -0,0 +1,167 @@
+package bench
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeRequestLog writes the records to a raw request log the same way a
+// Benchmark of the given mode does it and returns the file name.
+func writeRequestLog(t *testing.T, format RawLogFormat, mode Mode, records []RequestRecord) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "requests."+string(format))
+	b := &Benchmark{mode: mode}
+	if err := b.LogRequests(file, format); err != nil {
+		t.Fatalf("LogRequests() error = %v", err)
+	}
+	b.rawLog.start()
+	for _, r := range records {
+		b.rawLog.records <- r
+	}
+	if err := b.rawLog.close(); err != nil {
+		t.Fatalf("writing the raw log: %v", err)
+	}
+	return file
+}
+
+func TestSummarizeRequestLog(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	ms := time.Millisecond.Nanoseconds()
+	records := []RequestRecord{
+		{Intended: start, Sent: start, Latency: 20 * ms, Client: 0, Target: "a", StatusCode: 200, BytesReceived: 10},
+		{Intended: start + 100*ms, Sent: start + 100*ms, Latency: 5 * ms, Client: 1, Target: "b", StatusCode: 200, BytesReceived: 10},
+		{Intended: start + 200*ms, Sent: start + 200*ms, Latency: maxRecordableLatencyNS * 2, Client: 0, Target: "a", StatusCode: 200},
+		{Intended: start + 300*ms, Sent: start + 300*ms, Latency: 30 * ms, Client: 1, Target: "b", StatusCode: 500, Error: "HTTP 500"},
+	}
+
+	tests := []struct {
+		name        string
+		filter      RequestLogFilter
+		wantSuccess uint64
+		wantErrors  uint64
+		wantMin     time.Duration
+		wantMax     time.Duration
+	}{
+		{
+			name:        "all",
+			wantSuccess: 3,
+			wantErrors:  1,
+			wantMin:     5 * time.Millisecond,
+			wantMax:     maxRecordableLatencyNS,
+		},
+		{
+			// Latencies below the base latency are clamped to 0 instead of
+			// failing the report
+			name:        "base latency",
+			filter:      RequestLogFilter{BaseLatency: 10 * time.Millisecond},
+			wantSuccess: 3,
+			wantErrors:  1,
+			wantMin:     0,
+			wantMax:     maxRecordableLatencyNS,
+		},
+		{
+			name:        "target",
+			filter:      RequestLogFilter{Target: "b"},
+			wantSuccess: 1,
+			wantErrors:  1,
+			wantMin:     5 * time.Millisecond,
+			wantMax:     5 * time.Millisecond,
+		},
+		{
+			name:        "window",
+			filter:      RequestLogFilter{To: 150 * time.Millisecond},
+			wantSuccess: 2,
+			wantMin:     5 * time.Millisecond,
+			wantMax:     20 * time.Millisecond,
+		},
+	}
+
+	for _, format := range []RawLogFormat{RawLogCSV, RawLogJSONL, RawLogBinary} {
+		file := writeRequestLog(t, format, OpenLoop, records)
+		for _, tt := range tests {
+			t.Run(string(format)+"/"+tt.name, func(t *testing.T) {
+				s, err := SummarizeRequestLog(file, tt.filter)
+				if err != nil {
+					t.Fatalf("SummarizeRequestLog() error = %v", err)
+				}
+				if s.SuccessTotal != tt.wantSuccess || s.ErrorTotal != tt.wantErrors {
+					t.Errorf("successes, errors = %d, %d, want %d, %d", s.SuccessTotal, s.ErrorTotal, tt.wantSuccess, tt.wantErrors)
+				}
+				if !withinPrecision(s.SuccessHistogram.Min(), tt.wantMin) || !withinPrecision(s.SuccessHistogram.Max(), tt.wantMax) {
+					t.Errorf("latency range = %v..%v, want %v..%v",
+						time.Duration(s.SuccessHistogram.Min()), time.Duration(s.SuccessHistogram.Max()), tt.wantMin, tt.wantMax)
+				}
+			})
+		}
+	}
+}
+
+// withinPrecision reports whether a histogram value matches want. The lowest
+// trackable latency of the histograms is 1ms, which makes their buckets
+// rather coarse.
+func withinPrecision(got int64, want time.Duration) bool {
+	diff := got - want.Nanoseconds()
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= want.Nanoseconds()/10
+}
+
+func TestSummarizeRequestLogMatchesRun(t *testing.T) {
+	b, err := New(Options{Factory: testRequesterFactory{}, Connections: 2, MaxRequests: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "requests.csv")
+	if err := b.LogRequests(file, RawLogCSV); err != nil {
+		t.Fatal(err)
+	}
+	run, err := b.RunContext(context.Background())
+	if err != nil {
+		t.Fatalf("RunContext() error = %v", err)
+	}
+
+	s, err := SummarizeRequestLog(file, RequestLogFilter{})
+	if err != nil {
+		t.Fatalf("SummarizeRequestLog() error = %v", err)
+	}
+	if s.Mode != run.Mode || s.SuccessTotal != run.SuccessTotal || s.ErrorTotal != run.ErrorTotal {
+		t.Errorf("mode, successes, errors = %v, %d, %d, want %v, %d, %d",
+			s.Mode, s.SuccessTotal, s.ErrorTotal, run.Mode, run.SuccessTotal, run.ErrorTotal)
+	}
+	if !reflect.DeepEqual(s.Errors, run.Errors) {
+		t.Errorf("Errors = %v, want %v", s.Errors, run.Errors)
+	}
+	if s.RequestRate != 0 || !math.IsNaN(s.SendsTimelyRatio) {
+		t.Errorf("closed loop RequestRate, SendsTimelyRatio = %v, %v, want 0, NaN", s.RequestRate, s.SendsTimelyRatio)
+	}
+}
+
+func TestSummarizeRequestLogSelection(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	single := []RequestRecord{{Intended: start, Sent: start, Latency: 20e6, Target: "a", StatusCode: 200}}
+
+	if _, err := SummarizeRequestLog(writeRequestLog(t, RawLogCSV, OpenLoop, nil), RequestLogFilter{}); err == nil ||
+		!strings.Contains(err.Error(), "no requests logged") {
+		t.Errorf("SummarizeRequestLog() of an empty log error = %v", err)
+	}
+
+	file := writeRequestLog(t, RawLogCSV, OpenLoop, single)
+	if _, err := SummarizeRequestLog(file, RequestLogFilter{Target: "b"}); err == nil || !strings.Contains(err.Error(), "no requests match") {
+		t.Errorf("SummarizeRequestLog() without matches error = %v", err)
+	}
+
+	s, err := SummarizeRequestLog(file, RequestLogFilter{})
+	if err != nil {
+		t.Fatalf("SummarizeRequestLog() error = %v", err)
+	}
+	if !s.Start.Equal(time.Unix(0, start)) || s.TimeElapsed != 20*time.Millisecond || s.Throughput != 50 {
+		t.Errorf("single request Start, TimeElapsed, Throughput = %v, %v, %v", s.Start, s.TimeElapsed, s.Throughput)
+	}
+}
@@ -0,0 +1,490 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// chartPoint is a single data point of a chart series.
+type chartPoint struct {
+	X, Y float64
+}
+
+// chartSeries is a named line in a chart.
+type chartSeries struct {
+	Name   string
+	Color  string
+	Points []chartPoint
+}
+
+// lineChart describes an inline SVG line chart. Charts are rendered on the
+// server side so the report doesn't depend on any network-loaded scripts.
+type lineChart struct {
+	Title  string
+	XLabel string
+	YLabel string
+	Series []chartSeries
+
+	// LogX plots X on a log10 scale. It is used for the percentile
+	// distribution, where X is 1/(1-percentile).
+	LogX bool
+
+	// XTickLabel formats the X axis tick labels, defaults to %g.
+	XTickLabel func(x float64) string
+}
+
+const (
+	chartWidth        = 860
+	chartHeight       = 320
+	chartMarginLeft   = 70
+	chartMarginRight  = 20
+	chartMarginTop    = 40
+	chartMarginBottom = 50
+	chartYTicks       = 5
+	chartXTicks       = 8
+)
+
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b"}
+
+// svg renders the chart as an inline SVG element.
+func (c *lineChart) svg() template.HTML {
+	var (
+		buf          bytes.Buffer
+		minX, maxX   = math.Inf(1), math.Inf(-1)
+		maxY         float64
+		plotWidth    = float64(chartWidth - chartMarginLeft - chartMarginRight)
+		plotHeight   = float64(chartHeight - chartMarginTop - chartMarginBottom)
+		xTickLabel   = c.XTickLabel
+		pointsInPlot int
+	)
+
+	if xTickLabel == nil {
+		xTickLabel = func(x float64) string { return strconv.FormatFloat(x, 'g', 4, 64) }
+	}
+
+	scaleX := func(x float64) float64 {
+		if c.LogX {
+			return math.Log10(x)
+		}
+		return x
+	}
+
+	for _, series := range c.Series {
+		for _, p := range series.Points {
+			if math.IsInf(p.Y, 0) || math.IsNaN(p.Y) || math.IsInf(scaleX(p.X), 0) || math.IsNaN(scaleX(p.X)) {
+				continue
+			}
+			minX = math.Min(minX, scaleX(p.X))
+			maxX = math.Max(maxX, scaleX(p.X))
+			maxY = math.Max(maxY, p.Y)
+			pointsInPlot++
+		}
+	}
+
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" class="chart">`,
+		chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(&buf, `<text x="%d" y="20" class="title">%s</text>`, chartWidth/2, template.HTMLEscapeString(c.Title))
+
+	if pointsInPlot == 0 {
+		fmt.Fprintf(&buf, `<text x="%d" y="%d" class="nodata">No data</text></svg>`, chartWidth/2, chartHeight/2)
+		return template.HTML(buf.String())
+	}
+
+	if c.LogX {
+		minX = math.Floor(minX)
+		maxX = math.Ceil(maxX)
+	}
+	if maxX <= minX {
+		maxX = minX + 1
+	}
+	if maxY <= 0 {
+		maxY = 1
+	}
+	maxY = niceCeiling(maxY * 1.05)
+
+	px := func(x float64) float64 {
+		return chartMarginLeft + (scaleX(x)-minX)/(maxX-minX)*plotWidth
+	}
+	py := func(y float64) float64 {
+		return chartMarginTop + plotHeight - y/maxY*plotHeight
+	}
+
+	// Y grid and tick labels
+	for i := 0; i <= chartYTicks; i++ {
+		y := maxY * float64(i) / chartYTicks
+		fmt.Fprintf(&buf, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" class="grid"/>`,
+			chartMarginLeft, py(y), chartWidth-chartMarginRight, py(y))
+		fmt.Fprintf(&buf, `<text x="%d" y="%.1f" class="ytick">%s</text>`,
+			chartMarginLeft-6, py(y)+4, strconv.FormatFloat(y, 'g', 4, 64))
+	}
+
+	// X grid and tick labels
+	if c.LogX {
+		for d := minX; d <= maxX; d++ {
+			x := math.Pow(10, d)
+			fmt.Fprintf(&buf, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" class="grid"/>`,
+				px(x), chartMarginTop, px(x), chartHeight-chartMarginBottom)
+			fmt.Fprintf(&buf, `<text x="%.1f" y="%d" class="xtick">%s</text>`,
+				px(x), chartHeight-chartMarginBottom+16, template.HTMLEscapeString(xTickLabel(x)))
+		}
+	} else {
+		for i := 0; i <= chartXTicks; i++ {
+			x := minX + (maxX-minX)*float64(i)/chartXTicks
+			fmt.Fprintf(&buf, `<text x="%.1f" y="%d" class="xtick">%s</text>`,
+				px(x), chartHeight-chartMarginBottom+16, template.HTMLEscapeString(xTickLabel(x)))
+		}
+	}
+
+	fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="%.0f" height="%.0f" class="frame"/>`,
+		chartMarginLeft, chartMarginTop, plotWidth, plotHeight)
+	fmt.Fprintf(&buf, `<text x="%.1f" y="%d" class="xlabel">%s</text>`,
+		chartMarginLeft+plotWidth/2, chartHeight-10, template.HTMLEscapeString(c.XLabel))
+	fmt.Fprintf(&buf, `<text x="16" y="%.1f" class="ylabel" transform="rotate(-90 16 %.1f)">%s</text>`,
+		chartMarginTop+plotHeight/2, chartMarginTop+plotHeight/2, template.HTMLEscapeString(c.YLabel))
+
+	for i, series := range c.Series {
+		color := series.Color
+		if color == "" {
+			color = chartColors[i%len(chartColors)]
+		}
+
+		buf.WriteString(`<polyline fill="none" stroke-width="1.5" stroke="` + color + `" points="`)
+		for _, p := range series.Points {
+			if math.IsInf(p.Y, 0) || math.IsNaN(p.Y) || math.IsInf(scaleX(p.X), 0) || math.IsNaN(scaleX(p.X)) {
+				continue
+			}
+			fmt.Fprintf(&buf, "%.1f,%.1f ", px(p.X), py(p.Y))
+		}
+		buf.WriteString(`"/>`)
+
+		// Legend
+		legendY := chartMarginTop + 8 + i*16
+		fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`, chartWidth-chartMarginRight-110, legendY, color)
+		fmt.Fprintf(&buf, `<text x="%d" y="%d" class="legend">%s</text>`, chartWidth-chartMarginRight-95, legendY+9, template.HTMLEscapeString(series.Name))
+	}
+
+	buf.WriteString(`</svg>`)
+	return template.HTML(buf.String())
+}
+
+// niceCeiling rounds v up to 1, 2 or 5 times a power of ten, so axis ticks
+// land on readable values.
+func niceCeiling(v float64) float64 {
+	exp := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if v <= m*exp {
+			return m * exp
+		}
+	}
+	return 10 * exp
+}
+
+// percentileTickLabel labels a 1/(1-percentile) tick with its percentile,
+// e.g. 10 => 90%, 1000 => 99.9%.
+func percentileTickLabel(x float64) string {
+	return strconv.FormatFloat(100*(1-1/x), 'f', -1, 64) + "%"
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// reportMetric is a row of the report's metrics table.
+type reportMetric struct {
+	Name, Value string
+}
+
+// reportError is a row of the report's error breakdown table.
+type reportError struct {
+	Error      string
+	Count      int
+	Percentage string
+}
+
+type reportData struct {
+	Title     string
+	Generated string
+	Metrics   []reportMetric
+	Charts    []template.HTML
+	Errors    []reportError
+	Config    string
+}
+
+var reportTemplate = template.Must(template.Must(template.New("head").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+th { background: #f0f0f0; }
+td.num { text-align: right; }
+pre { background: #f6f6f6; padding: 1em; border: 1px solid #ddd; overflow-x: auto; }
+.chart { display: block; margin-bottom: 2em; }
+.chart text { font-size: 11px; fill: #333; }
+.chart .title { font-size: 14px; font-weight: bold; text-anchor: middle; }
+.chart .nodata { font-size: 14px; text-anchor: middle; fill: #999; }
+.chart .xtick, .chart .xlabel, .chart .ylabel { text-anchor: middle; }
+.chart .ytick { text-anchor: end; }
+.chart .grid { stroke: #e5e5e5; }
+.chart .frame { fill: none; stroke: #999; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Generated {{.Generated}}</p>
+`)).New("report").Parse(`{{template "head" .}}
+<h2>Summary</h2>
+<table>
+<tr><th>Metric</th><th>Value</th></tr>
+{{range .Metrics}}<tr><td>{{.Name}}</td><td class="num">{{.Value}}</td></tr>
+{{end}}</table>
+
+<h2>Charts</h2>
+{{range .Charts}}{{.}}
+{{end}}
+
+<h2>Errors</h2>
+{{if .Errors}}<table>
+<tr><th>Error</th><th>Absolute</th><th>Percentage %</th></tr>
+{{range .Errors}}<tr><td>{{.Error}}</td><td class="num">{{.Count}}</td><td class="num">{{.Percentage}}</td></tr>
+{{end}}</table>
+{{else}}<p>No errors.</p>
+{{end}}
+<h2>Configuration</h2>
+<pre>{{.Config}}</pre>
+</body>
+</html>
+`))
+
+var sweepReportTemplate = template.Must(template.Must(reportTemplate.Lookup("head").Clone()).New("sweep").Parse(`{{template "head" .}}
+<h2>Results</h2>
+<table>
+<tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td class="num">{{.}}</td>{{end}}</tr>
+{{end}}</table>
+
+<h2>Charts</h2>
+{{range .Charts}}{{.}}
+{{end}}
+<h2>Configuration</h2>
+<pre>{{.Config}}</pre>
+</body>
+</html>
+`))
+
+// GenerateHTMLReport writes a single self-contained HTML file with the
+// latency percentile distribution, latency, throughput and error rate over
+// time, the error breakdown and the given benchmark configuration. All charts
+// are embedded as SVG, so the report can be viewed offline.
+func (s *Summary) GenerateHTMLReport(config string, file string) error {
+	requestTotal := s.SuccessTotal + s.ErrorTotal
+	successRate := 0.
+	if requestTotal > 0 {
+		successRate = float64(s.SuccessTotal) / float64(requestTotal) * 100
+	}
+
+	data := reportData{
+		Title:     "LaBench report",
+		Generated: time.Now().UTC().Format(time.RFC3339),
+		Config:    config,
+		Metrics: []reportMetric{
+			{"Mode", string(s.Mode)},
+			{"Stopped By", string(s.StopReason)},
+			{"Abort Reason", s.AbortReason},
+			{"Total Requests", strconv.FormatUint(requestTotal, 10)},
+			{"Successful Requests", strconv.FormatUint(s.SuccessTotal, 10)},
+			{"Failed Requests", strconv.FormatUint(s.ErrorTotal, 10)},
+			{"Success Rate %", strconv.FormatFloat(successRate, 'f', 2, 64)},
+			{"Time Elapsed (sec)", strconv.FormatFloat(s.TimeElapsed.Seconds(), 'f', 2, 64)},
+			{"Request Rate (req/sec)", strconv.FormatFloat(s.RequestRate, 'f', 2, 64)},
+			{"Throughput (req/sec)", strconv.FormatFloat(s.Throughput, 'f', 2, 64)},
+			{"AvgRequestTime (ms)", strconv.FormatFloat(s.AvgRequestTime, 'f', 2, 64)},
+			{"Connections", strconv.FormatUint(s.Connections, 10)},
+			{"Think Time (ms)", strconv.FormatFloat(durationMS(s.ThinkTime), 'f', 2, 64)},
+			{"Timely Ticks %", formatRatio(s.TicksTimelyRatio)},
+			{"Timely Sends %", formatRatio(s.SendsTimelyRatio)},
+		},
+	}
+	if s.BytesSent > 0 || s.BytesReceived > 0 {
+		data.Metrics = append(data.Metrics,
+			reportMetric{"Bytes Sent", strconv.FormatUint(s.BytesSent, 10)},
+			reportMetric{"Bytes Received", strconv.FormatUint(s.BytesReceived, 10)})
+	}
+	for _, row := range s.resultRows() {
+		data.Metrics = append(data.Metrics, reportMetric{row[0] + " Requests", row[1]})
+	}
+	for _, row := range s.timingRows() {
+		data.Metrics = append(data.Metrics, reportMetric{row[0], row[1]})
+	}
+	if s.Overhead != nil {
+		for _, row := range s.Overhead.rows() {
+			data.Metrics = append(data.Metrics, reportMetric{"Generator " + row[0], row[1]})
+		}
+		for _, warning := range s.Overhead.Warnings {
+			data.Metrics = append(data.Metrics, reportMetric{"Warning", warning})
+		}
+	}
+
+	// Percentile distribution, the same data as in the .hgrm file
+	distribution := chartSeries{Name: "Latency"}
+	for _, percentile := range Logarithmic {
+		if percentile >= 100 {
+			continue
+		}
+		distribution.Points = append(distribution.Points, chartPoint{
+			X: 1 / (1 - percentile/100),
+			Y: float64(s.SuccessHistogram.ValueAtQuantile(percentile)) / 1000000,
+		})
+	}
+	data.Charts = append(data.Charts, (&lineChart{
+		Title:      "Latency by Percentile Distribution",
+		XLabel:     "Percentile",
+		YLabel:     "Latency (ms)",
+		Series:     []chartSeries{distribution},
+		LogX:       true,
+		XTickLabel: percentileTickLabel,
+	}).svg())
+
+	// Per interval charts
+	var (
+		p50, p90, p99, pMax = chartSeries{Name: "p50"}, chartSeries{Name: "p90"}, chartSeries{Name: "p99"}, chartSeries{Name: "max"}
+		throughput          = chartSeries{Name: "Throughput"}
+		errorRate           = chartSeries{Name: "Error rate"}
+	)
+	for _, interval := range s.Intervals {
+		if interval.Duration <= 0 {
+			continue
+		}
+		x := interval.Start.Add(interval.Duration).Sub(s.Intervals[0].Start).Seconds()
+		total := interval.SuccessTotal + interval.ErrorTotal
+
+		if interval.SuccessTotal > 0 {
+			p50.Points = append(p50.Points, chartPoint{x, durationMS(interval.P50)})
+			p90.Points = append(p90.Points, chartPoint{x, durationMS(interval.P90)})
+			p99.Points = append(p99.Points, chartPoint{x, durationMS(interval.P99)})
+			pMax.Points = append(pMax.Points, chartPoint{x, durationMS(interval.Max)})
+		}
+		throughput.Points = append(throughput.Points, chartPoint{x, float64(total) / interval.Duration.Seconds()})
+		if total > 0 {
+			errorRate.Points = append(errorRate.Points, chartPoint{x, float64(interval.ErrorTotal) * 100 / float64(total)})
+		} else {
+			errorRate.Points = append(errorRate.Points, chartPoint{x, 0})
+		}
+	}
+	throughputSeries := []chartSeries{throughput}
+	if len(s.RateTimeline) > 1 && len(s.Intervals) > 0 {
+		throughputSeries = append(throughputSeries, s.targetRateSeries())
+	}
+	data.Charts = append(data.Charts,
+		(&lineChart{Title: "Latency over Time", XLabel: "Time (sec)", YLabel: "Latency (ms)", Series: []chartSeries{p50, p90, p99, pMax}}).svg(),
+		(&lineChart{Title: "Throughput over Time", XLabel: "Time (sec)", YLabel: "Requests/sec", Series: throughputSeries}).svg(),
+		(&lineChart{Title: "Error Rate over Time", XLabel: "Time (sec)", YLabel: "Errors %", Series: []chartSeries{errorRate}}).svg(),
+	)
+
+	// Error breakdown, sorted by highest count
+	el := make(ErrorList, 0, len(s.Errors))
+	for code, count := range s.Errors {
+		el = append(el, Error{code, count})
+	}
+	sort.Sort(sort.Reverse(el))
+	for _, err := range el {
+		percentage := float64(err.Count) / float64(requestTotal) * 100
+		data.Errors = append(data.Errors, reportError{err.ErrorCode, err.Count, strconv.FormatFloat(percentage, 'f', 2, 64)})
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return reportTemplate.ExecuteTemplate(f, "report", data)
+}
+
+type sweepReportData struct {
+	Title     string
+	Generated string
+	Header    []string
+	Rows      [][]string
+	Charts    []template.HTML
+	Config    string
+}
+
+// targetRateSeries returns the rate timeline as a step function on the time
+// axis of the interval charts, pauses have a rate of 0.
+func (s *Summary) targetRateSeries() chartSeries {
+	var (
+		series = chartSeries{Name: "Target rate"}
+		origin = s.Intervals[0].Start
+		rate   float64
+	)
+	for i, change := range s.RateTimeline {
+		x := change.Time.Sub(origin).Seconds()
+		if i > 0 {
+			series.Points = append(series.Points, chartPoint{x, rate})
+		}
+		rate = change.Rate
+		if change.Paused {
+			rate = 0
+		}
+		series.Points = append(series.Points, chartPoint{x, rate})
+	}
+
+	last := s.Intervals[len(s.Intervals)-1]
+	series.Points = append(series.Points, chartPoint{last.Start.Add(last.Duration).Sub(origin).Seconds(), rate})
+	return series
+}
+
+// GenerateSweepReport writes a single self-contained HTML file with the
+// table and charts of latency percentiles, throughput and error rate versus
+// the request rate of each run, e.g. of a throughput-latency sweep.
+func (t SummaryTable) GenerateSweepReport(config string, file string) error {
+	data := sweepReportData{
+		Title:     "LaBench sweep report",
+		Generated: time.Now().UTC().Format(time.RFC3339),
+		Header:    summaryTableHeader,
+		Rows:      t.rows(),
+		Config:    config,
+	}
+
+	var (
+		percentiles = []float64{50, 90, 99, 99.9}
+		latency     = make([]chartSeries, len(percentiles))
+		throughput  = chartSeries{Name: "Throughput"}
+		errorRate   = chartSeries{Name: "Error rate"}
+	)
+	for i, p := range percentiles {
+		latency[i].Name = "p" + strconv.FormatFloat(p, 'f', -1, 64)
+	}
+	for _, run := range t {
+		s := run.Summary
+		for i, p := range percentiles {
+			latency[i].Points = append(latency[i].Points, chartPoint{s.RequestRate, durationMS(time.Duration(s.SuccessHistogram.ValueAtQuantile(p)))})
+		}
+		throughput.Points = append(throughput.Points, chartPoint{s.RequestRate, s.Throughput})
+		errorRate.Points = append(errorRate.Points, chartPoint{s.RequestRate, s.ErrorRate()})
+	}
+
+	data.Charts = append(data.Charts,
+		(&lineChart{Title: "Latency vs Request Rate", XLabel: "Request rate (req/sec)", YLabel: "Latency (ms)", Series: latency}).svg(),
+		(&lineChart{Title: "Throughput vs Request Rate", XLabel: "Request rate (req/sec)", YLabel: "Throughput (req/sec)", Series: []chartSeries{throughput}}).svg(),
+		(&lineChart{Title: "Error Rate vs Request Rate", XLabel: "Request rate (req/sec)", YLabel: "Errors %", Series: []chartSeries{errorRate}}).svg(),
+	)
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return sweepReportTemplate.ExecuteTemplate(f, "sweep", data)
+}
@@ -0,0 +1,62 @@
+package bench
+
+import (
+	"html"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateHTMLReport(t *testing.T) {
+	s := testSummary(t, OpenLoop, 2e6, 5e6, 40e6)
+	for i := 0; i < 3; i++ {
+		s.Intervals = append(s.Intervals, IntervalStats{
+			Start:        s.Start.Add(time.Duration(i) * time.Second),
+			Duration:     time.Second,
+			SuccessTotal: 1,
+			ErrorTotal:   uint64(i % 2),
+			P50:          5 * time.Millisecond,
+			P90:          10 * time.Millisecond,
+			P99:          40 * time.Millisecond,
+			Max:          40 * time.Millisecond,
+		})
+	}
+
+	config := "Request:\n  URL: http://localhost:8080/<path>?a=1&b=2\n"
+	file := filepath.Join(t.TempDir(), "report.html")
+	if err := s.GenerateHTMLReport(config, file); err != nil {
+		t.Fatalf("GenerateHTMLReport() error = %v", err)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := string(content)
+
+	if !strings.Contains(report, "<pre>"+html.EscapeString(config)+"</pre>") {
+		t.Error("report doesn't embed the config")
+	}
+	for _, title := range []string{"Latency by Percentile Distribution", "Latency over Time", "Throughput over Time", "Error Rate over Time"} {
+		if !strings.Contains(report, `class="title">`+title+"</text>") {
+			t.Errorf("report doesn't contain the %q chart", title)
+		}
+	}
+	if n := strings.Count(report, "<svg "); n != 4 {
+		t.Errorf("report contains %d SVG charts, want 4", n)
+	}
+	if !strings.Contains(report, "<td>Expected 200 got 503</td>") {
+		t.Error("report doesn't contain the error breakdown")
+	}
+
+	// Apart from the config, the only URL is the SVG namespace, which
+	// isn't loaded
+	rest := strings.Replace(report, html.EscapeString(config), "", 1)
+	rest = strings.Replace(rest, `xmlns="http://www.w3.org/2000/svg"`, "", -1)
+	for _, url := range []string{"://", `"//`, "'//", "(//"} {
+		if i := strings.Index(rest, url); i >= 0 {
+			t.Errorf("report references an external asset: %.60s", rest[i:])
+		}
+	}
+}
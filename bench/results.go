@@ -0,0 +1,117 @@
+package bench
+
+import (
+	"sort"
+	"strconv"
+)
+
+const (
+	// serverTimeTiming is the name of the histogram of the server times
+	// reported in RequestDetails, clientTimeTiming the one of the latencies
+	// of the same requests.
+	serverTimeTiming = "Server time"
+	clientTimeTiming = "Client time"
+)
+
+// resultTotals aggregates the RequestDetails of all requests, it's only used
+// by the collector.
+type resultTotals struct {
+	bytesSent     uint64
+	bytesReceived uint64
+	statusCodes   map[int]uint64
+	tags          map[string]uint64
+}
+
+func newResultTotals() resultTotals {
+	return resultTotals{
+		statusCodes: make(map[int]uint64),
+		tags:        make(map[string]uint64),
+	}
+}
+
+// add counts the details of a request.
+func (t *resultTotals) add(d *RequestDetails) {
+	if d.BytesSent > 0 {
+		t.bytesSent += uint64(d.BytesSent)
+	}
+	if d.BytesReceived > 0 {
+		t.bytesReceived += uint64(d.BytesReceived)
+	}
+	if d.HasStatus {
+		t.statusCodes[d.StatusCode]++
+	}
+	for name, value := range d.Tags {
+		t.tags[name+"="+value]++
+	}
+}
+
+// summarize copies the totals to the Summary.
+func (t *resultTotals) summarize(s *Summary) {
+	s.BytesSent = t.bytesSent
+	s.BytesReceived = t.bytesReceived
+	s.StatusCodes = nil
+	for code, count := range t.statusCodes {
+		if s.StatusCodes == nil {
+			s.StatusCodes = make(map[int]uint64, len(t.statusCodes))
+		}
+		s.StatusCodes[code] = count
+	}
+	s.Tags = nil
+	for tag, count := range t.tags {
+		if s.Tags == nil {
+			s.Tags = make(map[string]uint64, len(t.tags))
+		}
+		s.Tags[tag] = count
+	}
+}
+
+// mergeResults adds the result totals of src to dst.
+func mergeResults(dst, src *Summary) {
+	dst.BytesSent += src.BytesSent
+	dst.BytesReceived += src.BytesReceived
+	for code, count := range src.StatusCodes {
+		if dst.StatusCodes == nil {
+			dst.StatusCodes = make(map[int]uint64)
+		}
+		dst.StatusCodes[code] += count
+	}
+	for tag, count := range src.Tags {
+		if dst.Tags == nil {
+			dst.Tags = make(map[string]uint64)
+		}
+		dst.Tags[tag] += count
+	}
+}
+
+// resultRows returns the number and percentage of requests by status code and
+// by tag, status codes first.
+func (s *Summary) resultRows() [][]string {
+	requestTotal := s.SuccessTotal + s.ErrorTotal
+	row := func(name string, count uint64) []string {
+		percentage := 0.
+		if requestTotal > 0 {
+			percentage = float64(count) / float64(requestTotal) * 100
+		}
+		return []string{name, strconv.FormatUint(count, 10), strconv.FormatFloat(percentage, 'f', 2, 64)}
+	}
+
+	var rows [][]string
+	codes := make([]int, 0, len(s.StatusCodes))
+	for code := range s.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		rows = append(rows, row("Status "+strconv.Itoa(code), s.StatusCodes[code]))
+	}
+
+	tags := make([]string, 0, len(s.Tags))
+	for tag := range s.Tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		rows = append(rows, row("Tag "+tag, s.Tags[tag]))
+	}
+	return rows
+}
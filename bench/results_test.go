@@ -0,0 +1,52 @@
+package bench
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResultTotals(t *testing.T) {
+	details := []RequestDetails{
+		{StatusCode: 0, HasStatus: true, BytesSent: 10, BytesReceived: 100},
+		{StatusCode: 0, HasStatus: true, BytesSent: 10, BytesReceived: 50, Tags: map[string]string{"cache": "hit"}},
+		{StatusCode: 5, HasStatus: true, BytesSent: 10, Tags: map[string]string{"cache": "miss"}},
+		{BytesSent: 10, BytesReceived: -1},
+		{},
+	}
+
+	totals := newResultTotals()
+	for i := range details {
+		totals.add(&details[i])
+	}
+	var s Summary
+	totals.summarize(&s)
+
+	if s.BytesSent != 40 || s.BytesReceived != 150 {
+		t.Errorf("bytes sent, received = %d, %d, want 40, 150", s.BytesSent, s.BytesReceived)
+	}
+	if want := map[int]uint64{0: 2, 5: 1}; !reflect.DeepEqual(s.StatusCodes, want) {
+		t.Errorf("StatusCodes = %v, want %v", s.StatusCodes, want)
+	}
+	if want := map[string]uint64{"cache=hit": 1, "cache=miss": 1}; !reflect.DeepEqual(s.Tags, want) {
+		t.Errorf("Tags = %v, want %v", s.Tags, want)
+	}
+
+	merged := Summary{StatusCodes: map[int]uint64{5: 1}, BytesSent: 1}
+	mergeResults(&merged, &s)
+	if want := map[int]uint64{0: 2, 5: 2}; !reflect.DeepEqual(merged.StatusCodes, want) {
+		t.Errorf("merged StatusCodes = %v, want %v", merged.StatusCodes, want)
+	}
+	if merged.BytesSent != 41 {
+		t.Errorf("merged BytesSent = %d, want 41", merged.BytesSent)
+	}
+}
+
+func TestResultTotalsEmpty(t *testing.T) {
+	totals := newResultTotals()
+	totals.add(&RequestDetails{})
+	var s Summary
+	totals.summarize(&s)
+	if s.StatusCodes != nil || s.Tags != nil {
+		t.Errorf("StatusCodes, Tags = %v, %v, want nil", s.StatusCodes, s.Tags)
+	}
+}
@@ -0,0 +1,101 @@
+package bench
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// benchmarkServer serves HTTP endpoints of the latest Benchmark attached to
+// its address, so consecutive benchmarks in the same process can share it,
+// as can the endpoints of a single benchmark.
+type benchmarkServer struct {
+	benchmark atomic.Value // *Benchmark
+	addr      string
+	server    *http.Server
+	mux       *http.ServeMux
+	patterns  map[string]bool
+}
+
+// serverShutdownTimeout is how long a server waits for the requests in flight
+// when it's shut down.
+const serverShutdownTimeout = 5 * time.Second
+
+var (
+	benchmarkServersMu sync.Mutex
+	benchmarkServers   = make(map[string]*benchmarkServer)
+)
+
+// attachServer makes b the Benchmark served on addr, starting a server in
+// the background if there isn't one yet. The server runs until the run of
+// the Benchmark attached last ends, see closeServers.
+func (b *Benchmark) attachServer(addr string) (*benchmarkServer, error) {
+	benchmarkServersMu.Lock()
+	defer benchmarkServersMu.Unlock()
+
+	if srv, ok := benchmarkServers[addr]; ok {
+		srv.benchmark.Store(b)
+		b.servers = append(b.servers, srv)
+		return srv, nil
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &benchmarkServer{addr: addr, mux: http.NewServeMux(), patterns: make(map[string]bool)}
+	srv.server = &http.Server{Handler: srv.mux, ReadHeaderTimeout: 10 * time.Second}
+	srv.benchmark.Store(b)
+	benchmarkServers[addr] = srv
+	b.servers = append(b.servers, srv)
+
+	go func() {
+		_ = srv.server.Serve(listener)
+	}()
+
+	return srv, nil
+}
+
+// closeServers shuts down the servers b is still attached to, after waiting
+// for the requests in flight, e.g. the /stop which ended the run. Servers
+// which a later Benchmark took over keep running.
+func (b *Benchmark) closeServers() {
+	benchmarkServersMu.Lock()
+	var servers []*benchmarkServer
+	for _, srv := range b.servers {
+		if srv.benchmark.Load() == b && benchmarkServers[srv.addr] == srv {
+			delete(benchmarkServers, srv.addr)
+			servers = append(servers, srv)
+		}
+	}
+	b.servers = nil
+	benchmarkServersMu.Unlock()
+
+	for _, srv := range servers {
+		ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		if err := srv.server.Shutdown(ctx); err != nil {
+			b.logger.Printf("Shutting down the server on %s: %v", srv.addr, err)
+		}
+		cancel()
+	}
+}
+
+// handle registers handler for pattern unless it already is, the handler is
+// called with the Benchmark currently attached to the server.
+func (srv *benchmarkServer) handle(pattern string, handler func(b *Benchmark, w http.ResponseWriter, r *http.Request)) {
+	benchmarkServersMu.Lock()
+	defer benchmarkServersMu.Unlock()
+
+	if srv.patterns[pattern] {
+		return
+	}
+	srv.patterns[pattern] = true
+
+	srv.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		handler(srv.benchmark.Load().(*Benchmark), w, r)
+	})
+}
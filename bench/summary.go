@@ -2,8 +2,11 @@ package bench
 
 import (
 	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"sort"
 	"strconv"
@@ -15,8 +18,15 @@ import (
 
 // Summary contains the results of a Benchmark run.
 type Summary struct {
+	Mode             Mode
+	ThinkTime        time.Duration
+	StopReason       StopReason
+	Aborted          bool
+	AbortReason      string
+	RateTimeline     []RateChange
 	Connections      uint64
 	RequestRate      float64
+	Start            time.Time
 	SuccessTotal     uint64
 	ErrorTotal       uint64
 	TimeElapsed      time.Duration
@@ -25,10 +35,31 @@ type Summary struct {
 	AvgRequestTime   float64
 	Errors           map[string]int
 	TicksTimely      uint64
+	TicksMissed      uint64
 	TicksTimelyRatio float64
 	SendsTimely      uint64
+	SendsLate        uint64
 	SendsTimelyRatio float64
 	OutputJson       bool
+	Intervals        []IntervalStats `json:"-"`
+
+	// Timings are the histograms of the additional timings measured by a
+	// TimingRequester, Counts the distributions of the counts of a
+	// CountingRequester, by name.
+	Timings map[string]*hdrhistogram.Histogram `json:"-"`
+	Counts  map[string]*hdrhistogram.Histogram `json:"-"`
+
+	// BytesSent and BytesReceived are the totals of all requests, StatusCodes
+	// and Tags count the requests by status code and by tag (as name=value),
+	// as reported by a DetailedRequester.
+	BytesSent     uint64
+	BytesReceived uint64
+	StatusCodes   map[int]uint64    `json:",omitempty"`
+	Tags          map[string]uint64 `json:",omitempty"`
+
+	// Overhead is the health of the load generator during the run, it's not
+	// available for summaries which were merged or rebuilt from a log.
+	Overhead *OverheadStats `json:",omitempty"`
 }
 
 // Struct and functions for sorting errors
@@ -42,6 +73,39 @@ func (p ErrorList) Len() int           { return len(p) }
 func (p ErrorList) Less(i, j int) bool { return p[i].Count < p[j].Count }
 func (p ErrorList) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
+// ErrorRate returns the percentage of requests which failed.
+func (s *Summary) ErrorRate() float64 {
+	requestTotal := s.SuccessTotal + s.ErrorTotal
+	if requestTotal == 0 {
+		return 0
+	}
+	return float64(s.ErrorTotal) / float64(requestTotal) * 100
+}
+
+// MarshalJSON implements json.Marshaler. The timely ratios which are not
+// available (NaN, e.g. of a ClosedLoop run) are omitted, JSON can't represent
+// them.
+func (s *Summary) MarshalJSON() ([]byte, error) {
+	type summary Summary
+	return json.Marshal(struct {
+		*summary
+		TicksTimelyRatio *float64 `json:",omitempty"`
+		SendsTimelyRatio *float64 `json:",omitempty"`
+	}{
+		summary:          (*summary)(s),
+		TicksTimelyRatio: availableRatio(s.TicksTimelyRatio),
+		SendsTimelyRatio: availableRatio(s.SendsTimelyRatio),
+	})
+}
+
+// availableRatio returns nil for a ratio which is not available.
+func availableRatio(ratio float64) *float64 {
+	if math.IsNaN(ratio) {
+		return nil
+	}
+	return &ratio
+}
+
 // String returns a stringified version of the Summary.
 func (s *Summary) String() string {
 	requestTotal := s.SuccessTotal + s.ErrorTotal
@@ -52,9 +116,13 @@ func (s *Summary) String() string {
 
 	var outputBuffer bytes.Buffer
 
+	if s.Aborted {
+		fmt.Fprintf(&outputBuffer, "\nABORTED: %s\n", s.AbortReason)
+	}
+
 	fmt.Fprintf(&outputBuffer,
-		"\n{SuccessRate: %.2f%%, Throughput: %.2f req/s, AvgRequestTime: %.2f ms, Connections: %d, RequestRate: %.0f, RequestTotal: %d, SuccessTotal: %d, ErrorTotal: %d, TimeElapsed: %s}\n",
-		successRate, s.Throughput, s.AvgRequestTime, s.Connections, s.RequestRate, requestTotal, s.SuccessTotal, s.ErrorTotal, s.TimeElapsed)
+		"\n{Mode: %s, SuccessRate: %.2f%%, Throughput: %.2f req/s, AvgRequestTime: %.2f ms, Connections: %d, RequestRate: %.0f, RequestTotal: %d, SuccessTotal: %d, ErrorTotal: %d, TimeElapsed: %s}\n",
+		s.Mode, successRate, s.Throughput, s.AvgRequestTime, s.Connections, s.RequestRate, requestTotal, s.SuccessTotal, s.ErrorTotal, s.TimeElapsed)
 
 	if s.OutputJson {
 		// Serializing Summary object into JSON
@@ -70,6 +138,16 @@ func (s *Summary) String() string {
 	metricsTable.SetHeader([]string{"Metric", "Absolute", "Percentage %"})
 
 	//Printing metric data as a table
+	metricsTable.Append([]string{"Mode", string(s.Mode), ""})
+	if s.Mode == ClosedLoop {
+		metricsTable.Append([]string{"Think Time (ms)", strconv.FormatFloat(durationMS(s.ThinkTime), 'f', 2, 64), ""})
+	}
+	if s.StopReason != "" {
+		metricsTable.Append([]string{"Stopped By", string(s.StopReason), ""})
+	}
+	if s.Aborted {
+		metricsTable.Append([]string{"Abort Reason", s.AbortReason, ""})
+	}
 	metricsTable.Append([]string{"Total Requests", strconv.FormatUint(requestTotal, 10), ""})
 	metricsTable.Append([]string{"Successful Requests", strconv.FormatUint(s.SuccessTotal, 10), strconv.FormatFloat(successRate, 'f', 2, 64)})
 	metricsTable.Append([]string{"Failed Requests", strconv.FormatUint(s.ErrorTotal, 10), strconv.FormatFloat(100-successRate, 'f', 2, 64)})
@@ -77,8 +155,12 @@ func (s *Summary) String() string {
 	metricsTable.Append([]string{"Request Rate (req/sec)", strconv.FormatFloat(s.RequestRate, 'f', 2, 64), ""})
 	metricsTable.Append([]string{"Throughput (req/sec)", strconv.FormatFloat(s.Throughput, 'f', 2, 64), ""})
 	metricsTable.Append([]string{"AvgRequestTime (ms)", strconv.FormatFloat(s.AvgRequestTime, 'f', 2, 64), ""})
-	metricsTable.Append([]string{"Timely Ticks", strconv.FormatUint(s.TicksTimely, 10), strconv.FormatFloat(s.TicksTimelyRatio, 'f', 2, 64)})
-	metricsTable.Append([]string{"Timely Sends", strconv.FormatUint(s.SendsTimely, 10), strconv.FormatFloat(s.SendsTimelyRatio, 'f', 2, 64)})
+	metricsTable.Append([]string{"Timely Ticks", strconv.FormatUint(s.TicksTimely, 10), formatRatio(s.TicksTimelyRatio)})
+	metricsTable.Append([]string{"Timely Sends", strconv.FormatUint(s.SendsTimely, 10), formatRatio(s.SendsTimelyRatio)})
+	if s.BytesSent > 0 || s.BytesReceived > 0 {
+		metricsTable.Append([]string{"Bytes Sent", strconv.FormatUint(s.BytesSent, 10), ""})
+		metricsTable.Append([]string{"Bytes Received", strconv.FormatUint(s.BytesReceived, 10), ""})
+	}
 
 	//Printing error results as a table
 	//Laying out headers and values
@@ -108,9 +190,167 @@ func (s *Summary) String() string {
 		errorTable.Render()
 	}
 
+	if results := s.resultRows(); len(results) > 0 {
+		resultsTable := tablewriter.NewWriter(&outputBuffer)
+		resultsTable.SetHeader([]string{"Result", "Absolute", "Percentage %"})
+		resultsTable.SetAutoWrapText(false)
+		resultsTable.AppendBulk(results)
+		outputBuffer.WriteString("\n")
+		resultsTable.Render()
+	}
+
+	if len(s.Timings) > 0 {
+		outputBuffer.WriteString("\n")
+		s.timingsTable(&outputBuffer).Render()
+	}
+	if len(s.Counts) > 0 {
+		outputBuffer.WriteString("\n")
+		s.countsTable(&outputBuffer).Render()
+	}
+
+	// The rate was changed while running
+	if len(s.RateTimeline) > 1 {
+		outputBuffer.WriteString("\nRate timeline:\n")
+		for _, change := range s.RateTimeline {
+			state := ""
+			if change.Paused {
+				state = " (paused)"
+			}
+			fmt.Fprintf(&outputBuffer, "  %8.1fs %10.2f req/s%s\n", change.Time.Sub(s.RateTimeline[0].Time).Seconds(), change.Rate, state)
+		}
+	}
+
+	if s.Overhead != nil {
+		overheadTable := tablewriter.NewWriter(&outputBuffer)
+		overheadTable.SetHeader([]string{"Generator", "Value"})
+		overheadTable.AppendBulk(s.Overhead.rows())
+		outputBuffer.WriteString("\n")
+		overheadTable.Render()
+
+		for _, warning := range s.Overhead.Warnings {
+			fmt.Fprintf(&outputBuffer, "\nWARNING: %s\n", warning)
+		}
+	}
+
 	return outputBuffer.String()
 }
 
+// histogramNames returns the names of the histograms, sorted.
+func histogramNames(histograms map[string]*hdrhistogram.Histogram) []string {
+	names := make([]string, 0, len(histograms))
+	for name := range histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// timingsTable returns a table with the percentiles of the timings.
+func (s *Summary) timingsTable(w io.Writer) *tablewriter.Table {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Timing", "Count", "p50 (ms)", "p90 (ms)", "p99 (ms)", "Max (ms)"})
+	for _, name := range histogramNames(s.Timings) {
+		h := s.Timings[name]
+		table.Append([]string{
+			name,
+			strconv.FormatInt(h.TotalCount(), 10),
+			formatNS(h.ValueAtQuantile(50)),
+			formatNS(h.ValueAtQuantile(90)),
+			formatNS(h.ValueAtQuantile(99)),
+			formatNS(h.Max()),
+		})
+	}
+	return table
+}
+
+// countsTable returns a table with the distributions of the counts per
+// request.
+func (s *Summary) countsTable(w io.Writer) *tablewriter.Table {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Count", "Requests", "Mean", "p50", "p99", "Max"})
+	for _, name := range histogramNames(s.Counts) {
+		h := s.Counts[name]
+		table.Append([]string{
+			name,
+			strconv.FormatInt(h.TotalCount(), 10),
+			strconv.FormatFloat(h.Mean(), 'f', 2, 64),
+			strconv.FormatInt(h.ValueAtQuantile(50), 10),
+			strconv.FormatInt(h.ValueAtQuantile(99), 10),
+			strconv.FormatInt(h.Max(), 10),
+		})
+	}
+	return table
+}
+
+// timingRows returns the count and main percentiles of the timings, and the
+// mean and maximum of the counts, as name and value pairs.
+func (s *Summary) timingRows() [][]string {
+	var rows [][]string
+	for _, name := range histogramNames(s.Timings) {
+		h := s.Timings[name]
+		rows = append(rows,
+			[]string{name + " Count", strconv.FormatInt(h.TotalCount(), 10)},
+			[]string{name + " p50 (ms)", formatNS(h.ValueAtQuantile(50))},
+			[]string{name + " p99 (ms)", formatNS(h.ValueAtQuantile(99))})
+	}
+	for _, name := range histogramNames(s.Counts) {
+		h := s.Counts[name]
+		rows = append(rows,
+			[]string{name + " Mean", strconv.FormatFloat(h.Mean(), 'f', 2, 64)},
+			[]string{name + " Max", strconv.FormatInt(h.Max(), 10)})
+	}
+	return rows
+}
+
+// formatNS formats a histogram value in nanoseconds as milliseconds.
+func formatNS(ns int64) string {
+	return strconv.FormatFloat(float64(ns)/1e6, 'f', 3, 64)
+}
+
+// formatRatio formats a percentage, ratios which are not available (e.g. ticks
+// of a Summary rebuilt from a raw request log) are NaN.
+func formatRatio(ratio float64) string {
+	if math.IsNaN(ratio) {
+		return "n/a"
+	}
+	return strconv.FormatFloat(ratio, 'f', 2, 64)
+}
+
+// WriteIntervalsCSV writes the per interval statistics of the run to a CSV
+// file, one line per interval. Times are in seconds since the start of the
+// first interval, latencies in milliseconds.
+func (s *Summary) WriteIntervalsCSV(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{"Start", "Duration", "Requests", "Successes", "Errors", "Throughput", "P50", "P90", "P99", "Max"})
+	for _, interval := range s.Intervals {
+		total := interval.SuccessTotal + interval.ErrorTotal
+		throughput := 0.
+		if interval.Duration > 0 {
+			throughput = float64(total) / interval.Duration.Seconds()
+		}
+		_ = w.Write([]string{
+			strconv.FormatFloat(interval.Start.Sub(s.Intervals[0].Start).Seconds(), 'f', 3, 64),
+			strconv.FormatFloat(interval.Duration.Seconds(), 'f', 3, 64),
+			strconv.FormatUint(total, 10),
+			strconv.FormatUint(interval.SuccessTotal, 10),
+			strconv.FormatUint(interval.ErrorTotal, 10),
+			strconv.FormatFloat(throughput, 'f', 2, 64),
+			strconv.FormatFloat(durationMS(interval.P50), 'f', 3, 64),
+			strconv.FormatFloat(durationMS(interval.P90), 'f', 3, 64),
+			strconv.FormatFloat(durationMS(interval.P99), 'f', 3, 64),
+			strconv.FormatFloat(durationMS(interval.Max), 'f', 3, 64),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
 // GenerateLatencyDistribution generates a text file containing the specified
 // latency distribution in a format plottable by
 // http://hdrhistogram.github.io/HdrHistogram/plotFiles.html. Percentiles is a
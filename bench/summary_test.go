@@ -0,0 +1,78 @@
+package bench
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummaryMarshalJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		summary     Summary
+		ticksRatio  interface{}
+		sendsRatio  interface{}
+		wantPresent bool
+	}{
+		{
+			name:        "open loop",
+			summary:     Summary{Mode: OpenLoop, TicksTimely: 3, TicksMissed: 1, SendsTimely: 1, SendsLate: 1, TimeElapsed: time.Second},
+			ticksRatio:  75.,
+			sendsRatio:  50.,
+			wantPresent: true,
+		},
+		{
+			name:    "closed loop",
+			summary: Summary{Mode: ClosedLoop, SendsTimely: 4, TimeElapsed: time.Second},
+		},
+		{
+			name:    "no ticks",
+			summary: Summary{Mode: OpenLoop},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.summary
+			s.computeRatios()
+			data, err := json.Marshal(&s)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+
+			var fields map[string]interface{}
+			if err := json.Unmarshal(data, &fields); err != nil {
+				t.Fatal(err)
+			}
+			ticks, ticksOK := fields["TicksTimelyRatio"]
+			sends, sendsOK := fields["SendsTimelyRatio"]
+			if ticksOK != tt.wantPresent || sendsOK != tt.wantPresent {
+				t.Fatalf("ratios present = %v, %v, want %v", ticksOK, sendsOK, tt.wantPresent)
+			}
+			if tt.wantPresent && (ticks != tt.ticksRatio || sends != tt.sendsRatio) {
+				t.Errorf("ratios = %v, %v, want %v, %v", ticks, sends, tt.ticksRatio, tt.sendsRatio)
+			}
+			if _, ok := fields["Mode"]; !ok {
+				t.Errorf("Mode missing from %s", data)
+			}
+		})
+	}
+}
+
+func TestSummaryStringClosedLoopJSON(t *testing.T) {
+	s := &Summary{Mode: ClosedLoop, SuccessTotal: 2, TimeElapsed: time.Second, OutputJson: true}
+	s.computeRatios()
+	if !math.IsNaN(s.TicksTimelyRatio) {
+		t.Fatalf("TicksTimelyRatio = %v, want NaN", s.TicksTimelyRatio)
+	}
+
+	out := s.String()
+	if strings.Contains(out, "Error creating Json") {
+		t.Errorf("String() failed to encode the Summary:\n%s", out)
+	}
+	if !strings.Contains(out, "n/a") {
+		t.Errorf("String() doesn't render the unavailable ratios as n/a:\n%s", out)
+	}
+}
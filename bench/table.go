@@ -0,0 +1,76 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// NamedSummary is the Summary of one of several benchmark runs.
+type NamedSummary struct {
+	Name    string
+	Summary *Summary
+}
+
+// SummaryTable compares the results of several benchmark runs, one row per
+// run.
+type SummaryTable []NamedSummary
+
+var summaryTableHeader = []string{"Run", "Request Rate", "Throughput", "Requests", "Error %", "P50 (ms)", "P90 (ms)", "P99 (ms)", "P99.9 (ms)", "Max (ms)", "Timely Ticks %", "Timely Sends %"}
+
+func (t SummaryTable) rows() [][]string {
+	rows := make([][]string, len(t))
+	for i, run := range t {
+		s := run.Summary
+		percentile := func(p float64) string {
+			return strconv.FormatFloat(durationMS(time.Duration(s.SuccessHistogram.ValueAtQuantile(p))), 'f', 2, 64)
+		}
+		requestRate := strconv.FormatFloat(s.RequestRate, 'f', 2, 64)
+		if s.Mode == ClosedLoop {
+			requestRate = string(ClosedLoop)
+		}
+		rows[i] = []string{
+			run.Name,
+			requestRate,
+			strconv.FormatFloat(s.Throughput, 'f', 2, 64),
+			strconv.FormatUint(s.SuccessTotal+s.ErrorTotal, 10),
+			strconv.FormatFloat(s.ErrorRate(), 'f', 2, 64),
+			percentile(50),
+			percentile(90),
+			percentile(99),
+			percentile(99.9),
+			strconv.FormatFloat(durationMS(time.Duration(s.SuccessHistogram.Max())), 'f', 2, 64),
+			formatRatio(s.TicksTimelyRatio),
+			formatRatio(s.SendsTimelyRatio),
+		}
+	}
+	return rows
+}
+
+// String renders the table.
+func (t SummaryTable) String() string {
+	var buf bytes.Buffer
+	table := tablewriter.NewWriter(&buf)
+	table.SetHeader(summaryTableHeader)
+	table.AppendBulk(t.rows())
+	table.Render()
+	return buf.String()
+}
+
+// WriteCSV writes the table to a CSV file.
+func (t SummaryTable) WriteCSV(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	_ = w.Write(summaryTableHeader)
+	_ = w.WriteAll(t.rows())
+	return w.Error()
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"labench/bench"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configOverride sets the config field at the given dot separated path, e.g.
+// Request.Headers.Authorization, to a YAML value.
+type configOverride struct {
+	Path  string
+	Value interface{}
+}
+
+// setFlag collects repeated --set Path=Value command line flags.
+type setFlag []configOverride
+
+func (s *setFlag) String() string {
+	parts := make([]string, len(*s))
+	for i, o := range *s {
+		parts[i] = fmt.Sprintf("%s=%v", o.Path, o.Value)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *setFlag) Set(arg string) error {
+	eq := strings.Index(arg, "=")
+	if eq <= 0 {
+		return fmt.Errorf("expected Path=Value, got %q", arg)
+	}
+
+	*s = append(*s, configOverride{arg[:eq], parseYAMLValue(arg[eq+1:])})
+	return nil
+}
+
+// parseYAMLValue parses a command line value the same way it would be parsed
+// in the config file, so numbers and booleans keep their type. Anything which
+// doesn't parse as a YAML scalar is used as a plain string.
+func parseYAMLValue(s string) interface{} {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil || v == nil {
+		return s
+	}
+	switch v.(type) {
+	case map[interface{}]interface{}, []interface{}:
+		return s
+	}
+	return v
+}
+
+// benchmarkRun is a single benchmark to run. A config file describes either
+// one benchmark or, with a Runs list, several named ones.
+type benchmarkRun struct {
+	Name        string
+	Config      *config
+	ConfigBytes []byte
+}
+
+// runsFile is a config file describing several named benchmark runs, which
+// inherit their fields from Defaults and override them as necessary.
+type runsFile struct {
+	Defaults yaml.MapSlice   `yaml:"Defaults"`
+	CoolDown time.Duration   `yaml:"CoolDown"`
+	Runs     []yaml.MapSlice `yaml:"Runs"`
+}
+
+var (
+	yamlLineRegexp = regexp.MustCompile(`^line \d+: `)
+	runNameRegexp  = regexp.MustCompile(`^[\w.-]+$`)
+)
+
+// loadRuns reads the config file, applies the overrides to every run and
+// returns the effective configs, along with the cool-down between runs.
+func loadRuns(file string, overrides []configOverride) ([]benchmarkRun, time.Duration, error) {
+	configBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(configBytes, &doc); err != nil {
+		return nil, 0, fmt.Errorf("%s: %v", file, err)
+	}
+
+	hasRuns := false
+	for _, item := range doc {
+		hasRuns = hasRuns || item.Key == "Runs"
+	}
+
+	if !hasRuns {
+		conf, configBytes, errs := decodeConfig(configBytes, overrides)
+		if len(errs) > 0 {
+			return nil, 0, fmt.Errorf("%s: %v", file, errs)
+		}
+		return []benchmarkRun{{"", conf, configBytes}}, 0, nil
+	}
+
+	var (
+		rf   runsFile
+		errs configErrors
+		runs []benchmarkRun
+	)
+	if err := yaml.UnmarshalStrict(configBytes, &rf); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			for _, e := range typeErr.Errors {
+				errs = append(errs, configError{"", strings.Replace(e, "not found in type main.runsFile", "is not allowed next to Runs, move it to Defaults", 1)})
+			}
+		} else {
+			return nil, 0, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	if rf.CoolDown < 0 {
+		errs.add("CoolDown", "must not be negative")
+	}
+	if len(rf.Runs) == 0 {
+		errs.add("Runs", "must not be empty")
+	}
+
+	names := make(map[string]bool)
+	for i, run := range rf.Runs {
+		var name string
+		for j, item := range run {
+			if item.Key == "Name" {
+				name = fmt.Sprint(item.Value)
+				run = append(run[:j:j], run[j+1:]...)
+				break
+			}
+		}
+
+		path := fmt.Sprintf("Runs[%d]", i)
+		switch {
+		case name == "":
+			errs.add(path, "Name must be set")
+		case !runNameRegexp.MatchString(name):
+			errs.add(path, "Name %q may only contain letters, digits, '_', '-' and '.', it is used as the output directory", name)
+		case names[name]:
+			errs.add(path, "Name %q is not unique", name)
+		default:
+			path = fmt.Sprintf("Runs[%s]", name)
+		}
+		names[name] = true
+
+		// Line numbers refer to the merged run, not to the file, so they
+		// are dropped
+		merged, err := yaml.Marshal(mergeMaps(rf.Defaults, run))
+		if err != nil {
+			return nil, 0, err
+		}
+		conf, runBytes, runErrs := decodeConfig(merged, overrides)
+		for _, e := range runErrs {
+			e.Message = yamlLineRegexp.ReplaceAllString(e.Message, "")
+			if e.Path == "" {
+				e.Path = path
+			} else {
+				e.Path = path + "." + e.Path
+			}
+			errs = append(errs, e)
+		}
+
+		runs = append(runs, benchmarkRun{name, conf, runBytes})
+	}
+
+	if len(errs) > 0 {
+		return nil, 0, fmt.Errorf("%s: %v", file, errs)
+	}
+	return runs, rf.CoolDown, nil
+}
+
+// mergeMaps returns a copy of base with the fields of override merged in.
+// Mappings are merged recursively, any other value in override replaces the
+// one in base.
+func mergeMaps(base, override yaml.MapSlice) yaml.MapSlice {
+	merged := append(yaml.MapSlice(nil), base...)
+	for _, item := range override {
+		idx := -1
+		for i := range merged {
+			if merged[i].Key == item.Key {
+				idx = i
+				break
+			}
+		}
+
+		switch {
+		case idx < 0:
+			merged = append(merged, item)
+		default:
+			baseMap, baseIsMap := merged[idx].Value.(yaml.MapSlice)
+			overrideMap, overrideIsMap := item.Value.(yaml.MapSlice)
+			if baseIsMap && overrideIsMap {
+				merged[idx].Value = mergeMaps(baseMap, overrideMap)
+			} else {
+				merged[idx].Value = item.Value
+			}
+		}
+	}
+	return merged
+}
+
+// decodeConfig applies the overrides to a single benchmark config, decodes
+// and validates it, returning the effective config along with its YAML
+// representation.
+func decodeConfig(configBytes []byte, overrides []configOverride) (*config, []byte, configErrors) {
+	if len(overrides) > 0 {
+		var err error
+		if configBytes, err = applyOverrides(configBytes, overrides); err != nil {
+			return nil, nil, configErrors{{"", err.Error()}}
+		}
+	}
+
+	// Unknown fields and type mismatches don't stop decoding, so they are
+	// reported together with the validation errors
+	var (
+		conf   config
+		errs   configErrors
+		strict = yaml.UnmarshalStrict(configBytes, &conf)
+	)
+	if typeErr, ok := strict.(*yaml.TypeError); ok {
+		for _, e := range typeErr.Errors {
+			errs = append(errs, configError{"", describeYAMLError(e)})
+		}
+	} else if strict != nil {
+		return nil, nil, configErrors{{"", strict.Error()}}
+	}
+
+	errs = append(errs, conf.decodeRequest()...)
+	errs = append(errs, conf.validate()...)
+	return &conf, configBytes, errs
+}
+
+// configError is a single problem found in the config, Path is the dot
+// separated path of the offending field.
+type configError struct {
+	Path    string
+	Message string
+}
+
+// configErrors lists all the problems found in the config.
+type configErrors []configError
+
+func (errs configErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, e := range errs {
+		if e.Path == "" {
+			lines[i] = "  " + e.Message
+		} else {
+			lines[i] = "  " + e.Path + ": " + e.Message
+		}
+	}
+	return "invalid config:\n" + strings.Join(lines, "\n")
+}
+
+func (errs *configErrors) add(path, format string, args ...interface{}) {
+	*errs = append(*errs, configError{path, fmt.Sprintf(format, args...)})
+}
+
+// validate checks the config for invalid values and field combinations which
+// can't be expressed in the config types.
+func (c *config) validate() configErrors {
+	var errs configErrors
+
+	switch {
+	case c.Sweep != nil && c.Search != nil:
+		errs.add("Search", "is mutually exclusive with Sweep")
+	case c.Sweep != nil:
+		if c.Params.RequestRatePerSec != 0 {
+			errs.add("RequestRatePerSec", "is replaced by the Sweep rates, remove it")
+		}
+		c.Sweep.validate(&errs, "Sweep")
+	case c.Search != nil:
+		if c.Params.RequestRatePerSec != 0 {
+			errs.add("RequestRatePerSec", "is replaced by the Search probe rates, remove it")
+		}
+		c.Search.validate(&errs, "Search")
+	case c.Params.RequestRatePerSec == 0 && c.Params.Clients == 0:
+		errs.add("RequestRatePerSec", "must be positive, or 0 with Clients set for a closed-loop benchmark")
+	}
+	if c.Params.ThinkTime < 0 {
+		errs.add("ThinkTime", "must not be negative")
+	} else if c.Params.ThinkTime > 0 && (c.Params.RequestRatePerSec != 0 || c.Sweep != nil || c.Search != nil) {
+		errs.add("ThinkTime", "only applies to closed-loop benchmarks, where RequestRatePerSec is 0")
+	}
+	// Without a Duration the benchmark runs until another termination
+	// condition is met. Search probes run for ProbeDuration if it's set.
+	requireDuration := c.Params.MaxRequests == 0 && !c.exhaustible()
+	switch {
+	case c.Search != nil && c.Search.ProbeDuration > 0:
+		validateDuration(&errs, "Duration", c.Params.Duration, false)
+	case c.Search != nil && requireDuration && c.Params.Duration == 0:
+		errs.add("Duration", "either Duration or Search.ProbeDuration must be set, e.g. 30s")
+	default:
+		validateDuration(&errs, "Duration", c.Params.Duration, requireDuration)
+	}
+	validateDuration(&errs, "RequestTimeout", c.Params.RequestTimeout, false)
+	if c.Params.BaseLatency < 0 {
+		errs.add("BaseLatency", "must not be negative")
+	}
+
+	// Unknown protocols are reported by decodeRequest
+	if c.Request != nil {
+		c.Request.validate(&errs, "Request")
+	}
+
+	if c.Params.MetricsListenAddr != "" {
+		if _, _, err := net.SplitHostPort(c.Params.MetricsListenAddr); err != nil {
+			errs.add("MetricsListenAddr", "%v, expected host:port", err)
+		}
+	}
+	if c.Params.ControlListenAddr != "" {
+		if _, _, err := net.SplitHostPort(c.Params.ControlListenAddr); err != nil {
+			errs.add("ControlListenAddr", "%v, expected host:port", err)
+		}
+	}
+
+	for i, agent := range c.Params.Agents {
+		if _, _, err := net.SplitHostPort(agent); err != nil {
+			errs.add(fmt.Sprintf("Agents[%d]", i), "%v, expected host:port", err)
+		}
+	}
+	if len(c.Params.Agents) > 0 {
+		for _, field := range agentLocalResources(c) {
+			errs.add(field, "is not supported with Agents, they don't open listeners or read local files for a job")
+		}
+		if c.exhaustible() {
+			errs.add("Request", "stops the benchmark once all of its requests are made, which is not supported with Agents, each agent would make all of them")
+		}
+	}
+
+	switch bench.RawLogFormat(c.Params.RawLogFormat) {
+	case "", bench.RawLogCSV, bench.RawLogJSONL, bench.RawLogBinary:
+	default:
+		errs.add("RawLogFormat", "unknown format %q, supported formats are csv, jsonl and bin", c.Params.RawLogFormat)
+	}
+	if c.Params.RawLogFormat != "" && c.Params.RawLog == "" {
+		errs.add("RawLogFormat", "has no effect without RawLog")
+	}
+
+	if c.Abort != nil {
+		validateAbortConditions(&errs, "Abort", c.Abort)
+	}
+
+	return errs
+}
+
+// exhaustible reports whether the requests can run out, which ends the
+// benchmark.
+func (c *config) exhaustible() bool {
+	e, ok := c.Request.(exhaustibleConfig)
+	return ok && e.exhaustible()
+}
+
+func validateAbortConditions(errs *configErrors, path string, a *bench.AbortConditions) {
+	if a.MaxErrorRate == 0 && a.MaxConsecutiveFailures == 0 && a.MaxP99 == 0 {
+		errs.add(path, "either MaxErrorRate, MaxConsecutiveFailures or MaxP99 must be set")
+	}
+	if a.MaxErrorRate < 0 || a.MaxErrorRate >= 100 {
+		errs.add(path+".MaxErrorRate", "must be between 0 and 100")
+	}
+	validateDuration(errs, path+".ErrorRateWindow", a.ErrorRateWindow, false)
+	validateDuration(errs, path+".MaxP99", a.MaxP99, false)
+	if a.MaxP99Intervals < 0 {
+		errs.add(path+".MaxP99Intervals", "must not be negative")
+	}
+}
+
+// validateDuration reports durations which are negative or, in case they are
+// required, missing. Durations under a millisecond are most likely a number
+// missing its unit, which YAML decodes as nanoseconds.
+func validateDuration(errs *configErrors, path string, d time.Duration, required bool) {
+	switch {
+	case d < 0:
+		errs.add(path, "must not be negative")
+	case d == 0 && required:
+		errs.add(path, "must be set, e.g. 30s")
+	case d > 0 && d < time.Millisecond:
+		errs.add(path, "%v is too short, the unit is probably missing, e.g. %ds", d, d.Nanoseconds())
+	}
+}
+
+var unknownFieldRegexp = regexp.MustCompile(`field (\S+) not found in type (\S+)`)
+
+// describeYAMLError rewords strict decoding errors about unknown fields and
+// suggests the closest known field, which catches most typos.
+func describeYAMLError(e string) string {
+	m := unknownFieldRegexp.FindStringSubmatch(e)
+	if m == nil {
+		return e
+	}
+
+	msg := strings.Replace(e, m[0], "unknown field "+m[1], 1)
+	if suggestion := closestField(m[1], knownFields[m[2]]); suggestion != "" {
+		msg += ", did you mean " + suggestion + "?"
+	}
+	return msg
+}
+
+// knownFields maps config type names, as reported by the YAML decoder, to the
+// names of their fields.
+var knownFields = map[string][]string{}
+
+func init() {
+	addKnownFields(reflect.TypeOf(config{}))
+}
+
+// addKnownFields adds a config type, along with the types of its fields, to
+// knownFields.
+func addKnownFields(t reflect.Type) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if _, ok := knownFields[t.String()]; ok || t.Kind() != reflect.Struct {
+		return
+	}
+	knownFields[t.String()] = yamlFieldNames(t)
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.PkgPath == "" && f.Tag.Get("yaml") != "-" {
+			addKnownFields(f.Type)
+		}
+	}
+}
+
+func yamlFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("yaml")
+		switch {
+		case strings.Contains(tag, "inline"):
+			names = append(names, yamlFieldNames(f.Type)...)
+		case tag != "" && tag != "-":
+			names = append(names, strings.Split(tag, ",")[0])
+		}
+	}
+	return names
+}
+
+// closestField returns the known field with the smallest edit distance to
+// name, or an empty string if none is reasonably close.
+func closestField(name string, known []string) string {
+	best, bestDistance := "", len(name)/2+1
+	for _, k := range known {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(k)); d < bestDistance {
+			best, bestDistance = k, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// applyOverrides applies the overrides to the generic representation of the
+// YAML document, so the result is decoded exactly as if it had been written
+// in the config file.
+func applyOverrides(configBytes []byte, overrides []configOverride) ([]byte, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(configBytes, &doc); err != nil {
+		return nil, err
+	}
+
+	for _, o := range overrides {
+		var err error
+		if doc, err = setPath(doc, strings.Split(o.Path, "."), o.Value); err != nil {
+			return nil, fmt.Errorf("cannot set %s: %v", o.Path, err)
+		}
+	}
+
+	return yaml.Marshal(doc)
+}
+
+// setPath sets the value at the given path in a YAML mapping, creating
+// intermediate mappings as necessary. A nil value removes the key.
+func setPath(m yaml.MapSlice, path []string, value interface{}) (yaml.MapSlice, error) {
+	key := path[0]
+	idx := -1
+	for i, item := range m {
+		if fmt.Sprint(item.Key) == key {
+			idx = i
+			break
+		}
+	}
+
+	if len(path) == 1 {
+		switch {
+		case value == nil && idx >= 0:
+			return append(m[:idx:idx], m[idx+1:]...), nil
+		case value == nil:
+			return m, nil
+		case idx >= 0:
+			m[idx].Value = value
+			return m, nil
+		default:
+			return append(m, yaml.MapItem{Key: key, Value: value}), nil
+		}
+	}
+
+	// There's nothing to remove below a missing key
+	if value == nil && idx < 0 {
+		return m, nil
+	}
+
+	var child yaml.MapSlice
+	if idx >= 0 {
+		switch v := m[idx].Value.(type) {
+		case yaml.MapSlice:
+			child = v
+		case nil:
+		default:
+			return nil, fmt.Errorf("%s is not a mapping", key)
+		}
+	}
+
+	child, err := setPath(child, path[1:], value)
+	if err != nil {
+		return nil, err
+	}
+
+	if idx >= 0 {
+		m[idx].Value = child
+		return m, nil
+	}
+	return append(m, yaml.MapItem{Key: key, Value: child}), nil
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestValidateSearchDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		top     string // fields of the config
+		search  string // fields of the Search block
+		wantErr string
+	}{
+		{"ProbeDuration only", "", "ProbeDuration: 5s", ""},
+		{"Duration only", "Duration: 5s", "", ""},
+		{"both", "Duration: 5s", "ProbeDuration: 1s", ""},
+		{"neither", "", "", "Duration: either Duration or Search.ProbeDuration must be set"},
+		{"MaxRequests", "MaxRequests: 100", "", ""},
+		{"ProbeDuration without unit", "", "ProbeDuration: 5", "Search.ProbeDuration: 5ns is too short"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := fmt.Sprintf(`%s
+Request:
+  URL: http://localhost/
+Search:
+  %s
+  MinRate: 10
+  SLO:
+    Latency:
+      99: 100ms
+`, tt.top, tt.search)
+
+			_, _, errs := decodeConfig([]byte(config), nil)
+			switch {
+			case tt.wantErr == "" && len(errs) > 0:
+				t.Errorf("decodeConfig() errors = %v", errs)
+			case tt.wantErr != "" && !strings.Contains(errs.Error(), tt.wantErr):
+				t.Errorf("decodeConfig() errors = %v, want %q", errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadRunsErrors(t *testing.T) {
+	_, _, err := loadRuns("testdata/invalid_runs.yaml", nil)
+	if err == nil {
+		t.Fatal("loadRuns() error = nil")
+	}
+
+	// Every problem is reported, with the path of the run it's in
+	for _, want := range []string{
+		"field RequestRatePerSec is not allowed next to Runs, move it to Defaults",
+		"Runs[a]: unknown field RequestRatePerSecc, did you mean RequestRatePerSec?",
+		"Runs[a].Duration: 1ns is too short",
+		"Runs[1]: Name must be set",
+		"Runs[1].Request: either URL or URLs must be set",
+		`Runs[2]: Name "a" is not unique`,
+		`Runs[2].Request.URL: "x" must start with http:// or https://`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("loadRuns() error doesn't contain %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestReferenceConfigs(t *testing.T) {
+	for _, file := range []string{"full_config.yaml", "labench.yaml"} {
+		runs, _, err := loadRuns(file, nil)
+		if err != nil {
+			t.Errorf("loadRuns(%q) error = %v", file, err)
+			continue
+		}
+		// Optional features which bind ports or write files stay commented
+		params := runs[0].Config.Params
+		if params.MetricsListenAddr != "" || params.ControlListenAddr != "" || params.RawLog != "" || len(params.Agents) > 0 {
+			t.Errorf("%s enables an optional feature by default: %+v", file, params)
+		}
+	}
+}
+
+func TestParseYAMLValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		{"100", 100},
+		{"1.5", 1.5},
+		{"true", true},
+		{"30s", "30s"},
+		{"http://localhost:8080/", "http://localhost:8080/"},
+		{"", ""},
+		{"null", "null"},
+		{"[1, 2]", "[1, 2]"},
+		{"a: b", "a: b"},
+		{"'quoted'", "quoted"},
+		{"{", "{"},
+	}
+
+	for _, tt := range tests {
+		if got := parseYAMLValue(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseYAMLValue(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSetFlag(t *testing.T) {
+	var s setFlag
+	for _, arg := range []string{"Duration=30s", "Request.URL=http://localhost/?a=b"} {
+		if err := s.Set(arg); err != nil {
+			t.Fatalf("Set(%q) error = %v", arg, err)
+		}
+	}
+	if got, want := s.String(), "Duration=30s,Request.URL=http://localhost/?a=b"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	for _, arg := range []string{"Duration", "=30s"} {
+		if err := s.Set(arg); err == nil {
+			t.Errorf("Set(%q) succeeded", arg)
+		}
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	const config = `Duration: 10s
+Request:
+  URL: http://localhost/
+  Headers:
+    A: a
+`
+	tests := []struct {
+		name      string
+		overrides []configOverride
+		want      string
+		wantErr   string
+	}{
+		{
+			name:      "replace",
+			overrides: []configOverride{{"Duration", "30s"}},
+			want:      "Duration: 30s\nRequest:\n  URL: http://localhost/\n  Headers:\n    A: a\n",
+		},
+		{
+			name:      "nested",
+			overrides: []configOverride{{"Request.Headers.B", "b"}, {"Request.URL", "http://other/"}},
+			want:      "Duration: 10s\nRequest:\n  URL: http://other/\n  Headers:\n    A: a\n    B: b\n",
+		},
+		{
+			name:      "new mapping",
+			overrides: []configOverride{{"Search.MinRate", 10}},
+			want:      "Duration: 10s\nRequest:\n  URL: http://localhost/\n  Headers:\n    A: a\nSearch:\n  MinRate: 10\n",
+		},
+		{
+			name:      "remove",
+			overrides: []configOverride{{"Request.Headers", nil}, {"Missing", nil}, {"Other.Missing", nil}},
+			want:      "Duration: 10s\nRequest:\n  URL: http://localhost/\n",
+		},
+		{
+			name:      "not a mapping",
+			overrides: []configOverride{{"Duration.Seconds", 5}},
+			wantErr:   "cannot set Duration.Seconds: Duration is not a mapping",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyOverrides([]byte(config), tt.overrides)
+			switch {
+			case tt.wantErr != "":
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("applyOverrides() error = %v, want %q", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Errorf("applyOverrides() error = %v", err)
+			case string(got) != tt.want:
+				t.Errorf("applyOverrides() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"Duration", "Duration", 0},
+		{"Duraton", "Duration", 1},
+		{"Durration", "Duration", 1},
+		{"Dutarion", "Duration", 2},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestClosestField(t *testing.T) {
+	known := []string{"Duration", "RequestRatePerSec", "RequestTimeout", "URL", "URLs"}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Duraton", "Duration"},
+		{"duration", "Duration"},
+		{"RequestRatePerSecc", "RequestRatePerSec"},
+		{"RequestTimout", "RequestTimeout"},
+		{"Url", "URL"},
+		{"Urls", "URLs"},
+		{"Clients", ""},
+		{"X", ""},
+	}
+
+	for _, tt := range tests {
+		if got := closestField(tt.name, known); got != tt.want {
+			t.Errorf("closestField(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnknownFieldSuggestions(t *testing.T) {
+	tests := []struct {
+		config string
+		want   string
+	}{
+		{"Duraton: 10s\nRequest:\n  URL: http://localhost/\n", "unknown field Duraton, did you mean Duration?"},
+		{"Duration: 10s\nRequest:\n  Url: http://localhost/\n", "Request: unknown field Url, did you mean URL?"},
+		{"Duration: 10s\nProtocol: TCP\nRequest:\n  Adress: localhost:7\n", "Request: unknown field Adress, did you mean Address?"},
+		{"Duration: 10s\nBogus: 1\nRequest:\n  URL: http://localhost/\n", "unknown field Bogus\n"},
+		{"Duration: 10s\nProtocol: FTP\n", `Protocol: unknown protocol "FTP", supported protocols are`},
+	}
+
+	for _, tt := range tests {
+		_, _, errs := decodeConfig([]byte(tt.config), nil)
+		if got := errs.Error() + "\n"; !strings.Contains(got, tt.want) {
+			t.Errorf("decodeConfig(%q) errors = %v, want %q", tt.config, errs, tt.want)
+		}
+	}
+}
+
+func TestMergeMaps(t *testing.T) {
+	parse := func(s string) yaml.MapSlice {
+		var m yaml.MapSlice
+		if err := yaml.Unmarshal([]byte(s), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	tests := []struct {
+		name           string
+		base, override string
+		want           string
+	}{
+		{"empty override", "A: 1\nB: 2\n", "", "A: 1\nB: 2\n"},
+		{"empty base", "", "A: 1\n", "A: 1\n"},
+		{"replace and add", "A: 1\nB: 2\n", "B: 3\nC: 4\n", "A: 1\nB: 3\nC: 4\n"},
+		{"nested", "R:\n  P: 1\n  Q: 2\n", "R:\n  Q: 3\n  S: 4\n", "R:\n  P: 1\n  Q: 3\n  S: 4\n"},
+		{"mapping replaced by scalar", "R:\n  X: 1\n", "R: 2\n", "R: 2\n"},
+		{"lists are replaced", "L: [1, 2]\n", "L: [3]\n", "L:\n- 3\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := parse(tt.base)
+			baseBefore, _ := yaml.Marshal(base)
+
+			got, err := yaml.Marshal(mergeMaps(base, parse(tt.override)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("mergeMaps() = %q, want %q", got, tt.want)
+			}
+			// Defaults are merged into every run, so they must not change
+			if baseAfter, _ := yaml.Marshal(base); string(baseAfter) != string(baseBefore) {
+				t.Errorf("mergeMaps() changed base to %q", baseAfter)
+			}
+		})
+	}
+}
+
+func TestLoadRuns(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "runs.yaml")
+	err := ioutil.WriteFile(file, []byte(`Defaults:
+  Duration: 10s
+  RequestRatePerSec: 100
+  Request:
+    URL: http://localhost/
+    Headers:
+      A: a
+CoolDown: 5s
+Runs:
+- Name: default
+- Name: fast
+  RequestRatePerSec: 1000
+  Request:
+    Headers:
+      B: b
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs, coolDown, err := loadRuns(file, []configOverride{{"Duration", "1m"}})
+	if err != nil {
+		t.Fatalf("loadRuns() error = %v", err)
+	}
+	if coolDown != 5*time.Second {
+		t.Errorf("CoolDown = %v, want 5s", coolDown)
+	}
+	if len(runs) != 2 || runs[0].Name != "default" || runs[1].Name != "fast" {
+		t.Fatalf("runs = %+v, want default and fast", runs)
+	}
+
+	for i, want := range []uint64{100, 1000} {
+		params := runs[i].Config.Params
+		if params.RequestRatePerSec != want || params.Duration != time.Minute {
+			t.Errorf("%s: RequestRatePerSec, Duration = %d, %v, want %d, 1m", runs[i].Name, params.RequestRatePerSec, params.Duration, want)
+		}
+	}
+	headers := runs[1].Config.Request.(*WebRequesterFactory).Headers
+	if !reflect.DeepEqual(headers, map[string]string{"A": "a", "B": "b"}) {
+		t.Errorf("fast: Headers = %v, want A and B", headers)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"labench/bench"
+)
+
+// agentStartDelay is how far in the future the coordinator schedules the
+// start of the agents, long enough for all of them to receive their job and
+// set up their connections.
+const agentStartDelay = 3 * time.Second
+
+// agentJob is the work the coordinator sends to an agent: the config of the
+// agent's share of the benchmark and the wall-clock time to start it at. The
+// agent sets the benchmark up before waiting for the start time.
+type agentJob struct {
+	Config  string
+	StartAt time.Time
+}
+
+// agentMain listens for jobs from a coordinator, runs them one at a time and
+// replies with the encoded summary. Jobs aren't authenticated, so the agent
+// listens on localhost unless told otherwise, and refuses jobs which would
+// make it open listeners or read local files. Raw logs are written to its out
+// directory.
+func agentMain(args []string) {
+	var (
+		flags  = flag.NewFlagSet("agent", flag.ExitOnError)
+		listen string
+		outDir string
+	)
+
+	flags.StringVar(&listen, "listen", "localhost:7070", "address to listen for jobs on, e.g. :7070 for all interfaces; anyone who can reach it can run benchmarks")
+	flags.StringVar(&outDir, "out", path.Join("out", "agent"), "directory to write the results of each job to")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s agent [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	_ = flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	http.HandleFunc("/run", agentHandler(outDir))
+
+	fmt.Println("Agent listening on", listen)
+	exitOnError(http.ListenAndServe(listen, nil))
+}
+
+// agentHandler runs the jobs posted to it one at a time, writing their
+// results to outDir.
+func agentHandler(outDir string) http.HandlerFunc {
+	var busy int32
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if !atomic.CompareAndSwapInt32(&busy, 0, 1) {
+			http.Error(w, "agent is busy running another benchmark", http.StatusConflict)
+			return
+		}
+		defer atomic.StoreInt32(&busy, 0)
+
+		var job agentJob
+		if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+			http.Error(w, "invalid job: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		conf, configBytes, errs := decodeConfig([]byte(job.Config), nil)
+		if len(errs) > 0 {
+			http.Error(w, errs.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(conf.Params.Agents) > 0 || conf.Sweep != nil || conf.Search != nil {
+			http.Error(w, "agents only run plain benchmarks", http.StatusBadRequest)
+			return
+		}
+		if fields := agentLocalResources(conf); len(fields) > 0 {
+			http.Error(w, "agents don't open listeners or read local files for a job, remove "+strings.Join(fields, ", "), http.StatusBadRequest)
+			return
+		}
+		if conf.Params.RawLog != "" {
+			rawLog, err := agentRawLog(conf.Params.RawLog, outDir)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := os.MkdirAll(outDir, os.ModeDir|os.ModePerm); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			conf.Params.RawLog = rawLog
+		}
+
+		fmt.Printf("\n=== Job from %s, starting at %v\n", r.RemoteAddr, job.StartAt.UTC())
+		summary, err := runBenchmark(conf, configBytes, outDir, job.StartAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(summary.Encode())
+	}
+}
+
+// agentLocalResources returns the fields of a job's config which would make
+// an agent open listeners or read its local files.
+func agentLocalResources(conf *config) []string {
+	var fields []string
+	if conf.Params.MetricsListenAddr != "" {
+		fields = append(fields, "MetricsListenAddr")
+	}
+	if conf.Params.ControlListenAddr != "" {
+		fields = append(fields, "ControlListenAddr")
+	}
+	if g, ok := conf.Request.(*GRPCRequesterFactory); ok {
+		if g.DescriptorSet != "" {
+			fields = append(fields, "Request.DescriptorSet")
+		}
+		if g.TLS != nil && g.TLS.CACert != "" {
+			fields = append(fields, "Request.TLS.CACert")
+		}
+	}
+	return fields
+}
+
+// agentRawLog returns the file an agent writes the raw log of a job to, the
+// file name of RawLog in the agent's out directory.
+func agentRawLog(rawLog, outDir string) (string, error) {
+	name := filepath.Base(rawLog)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return "", fmt.Errorf("RawLog: %q is not a file name", rawLog)
+	}
+	return filepath.Join(outDir, name), nil
+}
+
+// runDistributed coordinates a benchmark across the configured agents: the
+// request rate, clients and request limit are split evenly between them, they
+// are started at the same wall-clock time, and their results are merged into
+// one summary.
+func runDistributed(conf *config, configBytes []byte, outDir string) (*bench.Summary, error) {
+	var (
+		agents      = conf.Params.Agents
+		rates       = splitEvenly(conf.Params.RequestRatePerSec, len(agents))
+		clients     = splitEvenly(conf.Params.Clients, len(agents))
+		maxRequests = splitEvenly(conf.Params.MaxRequests, len(agents))
+		startAt     = time.Now().Add(agentStartDelay)
+	)
+
+	jobs := make([]agentJob, len(agents))
+	for i := range agents {
+		overrides := []configOverride{{"Agents", nil}}
+		if conf.Params.RequestRatePerSec > 0 {
+			if rates[i] == 0 {
+				return nil, fmt.Errorf("RequestRatePerSec %d can't be split across %d agents", conf.Params.RequestRatePerSec, len(agents))
+			}
+			overrides = append(overrides, configOverride{"RequestRatePerSec", rates[i]})
+		}
+		if conf.Params.Clients > 0 {
+			if clients[i] == 0 {
+				return nil, fmt.Errorf("Clients %d can't be split across %d agents", conf.Params.Clients, len(agents))
+			}
+			overrides = append(overrides, configOverride{"Clients", clients[i]})
+		}
+		if conf.Params.MaxRequests > 0 {
+			if maxRequests[i] == 0 {
+				return nil, fmt.Errorf("MaxRequests %d can't be split across %d agents", conf.Params.MaxRequests, len(agents))
+			}
+			overrides = append(overrides, configOverride{"MaxRequests", maxRequests[i]})
+		}
+
+		_, agentConfig, errs := decodeConfig(configBytes, overrides)
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		jobs[i] = agentJob{Config: string(agentConfig), StartAt: startAt}
+	}
+
+	fmt.Printf("Starting %d agents at %v\n", len(agents), startAt.UTC())
+
+	var (
+		wg        sync.WaitGroup
+		summaries = make([]*bench.Summary, len(agents))
+		errs      = make([]error, len(agents))
+	)
+	wg.Add(len(agents))
+	for i := range agents {
+		i := i
+		go func() {
+			defer wg.Done()
+			summaries[i], errs[i] = runAgentJob(agents[i], jobs[i])
+		}()
+	}
+	wg.Wait()
+
+	var table bench.SummaryTable
+	for i, agent := range agents {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("agent %s: %v", agent, errs[i])
+		}
+		table = append(table, bench.NamedSummary{Name: agent, Summary: summaries[i]})
+	}
+
+	summary, err := bench.MergeSummaries(summaries...)
+	if err != nil {
+		return nil, err
+	}
+	summary.OutputJson = conf.Params.OutputJSON
+
+	fmt.Println("\nAgents:")
+	fmt.Println(table)
+	fmt.Println(summary)
+
+	if err := writeResults(summary, configBytes, outDir); err != nil {
+		return nil, err
+	}
+	if err := table.WriteCSV(path.Join(outDir, "agents.csv")); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// runAgentJob sends a job to an agent and waits for its summary.
+func runAgentJob(agent string, job agentJob) (*bench.Summary, error) {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+
+	// #nosec
+	resp, err := http.Post("http://"+agent+"/run", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, errors.New(string(bytes.TrimSpace(msg)))
+	}
+
+	var encoded bench.EncodedSummary
+	if err := json.NewDecoder(resp.Body).Decode(&encoded); err != nil {
+		return nil, fmt.Errorf("invalid summary: %v", err)
+	}
+	return encoded.Decode()
+}
+
+// splitEvenly splits total into n shares which differ by at most 1.
+func splitEvenly(total uint64, n int) []uint64 {
+	shares := make([]uint64, n)
+	for i := range shares {
+		shares[i] = total / uint64(n)
+		if uint64(i) < total%uint64(n) {
+			shares[i]++
+		}
+	}
+	return shares
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const agentTestConfig = `RequestRatePerSec: 10
+Duration: 1s
+`
+
+func TestAgentHandlerRefusesLocalResources(t *testing.T) {
+	grpc := `Protocol: gRPC
+Request:
+  Target: localhost:50051
+  Method: pkg.Service/Method
+`
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"metrics", "MetricsListenAddr: :9090\nRequest:\n  URL: http://localhost/\n", "remove MetricsListenAddr"},
+		{"control", "ControlListenAddr: :9091\nRequest:\n  URL: http://localhost/\n", "remove ControlListenAddr"},
+		{"descriptor set", grpc + "  DescriptorSet: /etc/passwd\n", "remove Request.DescriptorSet"},
+		{"CA certificate", grpc + "  TLS:\n    CACert: /etc/passwd\n", "remove Request.TLS.CACert"},
+		{"raw log", "RawLog: ..\nRequest:\n  URL: http://localhost/\n", `RawLog: ".." is not a file name`},
+	}
+
+	handler := agentHandler(t.TempDir())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(agentJob{Config: agentTestConfig + tt.config, StartAt: time.Now()})
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodPost, "/run", bytes.NewReader(body)))
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.wantErr) {
+				t.Errorf("response = %d %q, want %d %q", w.Code, w.Body.String(), http.StatusBadRequest, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAgentRawLog(t *testing.T) {
+	out := filepath.Join("out", "agent")
+	tests := []struct {
+		rawLog  string
+		want    string
+		wantErr bool
+	}{
+		{"requests.csv", filepath.Join(out, "requests.csv"), false},
+		{filepath.Join("logs", "requests.bin"), filepath.Join(out, "requests.bin"), false},
+		{filepath.Join("..", "..", "etc", "cron.d", "x"), filepath.Join(out, "x"), false},
+		{"..", "", true},
+		{string(filepath.Separator), "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := agentRawLog(tt.rawLog, out)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("agentRawLog(%q) = %q, %v, want %q, error %v", tt.rawLog, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateAgentsLocalResources(t *testing.T) {
+	config := agentTestConfig + `Agents: [localhost:7070]
+MetricsListenAddr: :9090
+Protocol: gRPC
+Request:
+  Target: localhost:50051
+  Method: pkg.Service/Method
+  DescriptorSet: service.pb
+`
+	_, _, errs := decodeConfig([]byte(config), nil)
+	for _, field := range []string{"MetricsListenAddr", "Request.DescriptorSet"} {
+		if !strings.Contains(errs.Error(), field+": is not supported with Agents") {
+			t.Errorf("decodeConfig() errors = %v, want %s rejected", errs, field)
+		}
+	}
+}
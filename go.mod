@@ -1,7 +1,11 @@
 module labench
 
+go 1.16
+
 require (
 	golang.org/x/net v0.0.0-20190522155817-f3200d17e092
+	google.golang.org/grpc v1.38.0
+	google.golang.org/protobuf v1.26.0
 	gopkg.in/yaml.v2 v2.2.2
 	labench/bench v0.0.0
 )
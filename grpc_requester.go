@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"labench/bench"
+)
+
+func init() {
+	registerProtocol("gRPC", func() requesterConfig { return &GRPCRequesterFactory{} })
+}
+
+// grpcMethodRegexp matches full gRPC method names, e.g.
+// /helloworld.Greeter/SayHello, the leading slash is optional.
+var grpcMethodRegexp = regexp.MustCompile(`^/?([\w.]+)/(\w+)$`)
+
+// GRPCRequesterFactory implements RequesterFactory by creating a Requester
+// which makes unary gRPC calls. The request Message is given as JSON and
+// encoded using the method's descriptor, which is read from a descriptor set
+// or, if none is given, fetched by server reflection.
+type GRPCRequesterFactory struct {
+	Target             string            `yaml:"Target"`
+	Method             string            `yaml:"Method"`
+	Message            string            `yaml:"Message"`
+	DescriptorSet      string            `yaml:"DescriptorSet"`
+	Metadata           map[string]string `yaml:"Metadata"`
+	TLS                *GRPCTLSConfig    `yaml:"TLS"`
+	ExpectedStatusCode string            `yaml:"ExpectedStatusCode"`
+
+	// Connections is the number of HTTP/2 connections the calls are spread
+	// over, defaults to 1.
+	Connections int `yaml:"Connections"`
+
+	conns          []*grpc.ClientConn
+	method         string
+	payload        []byte
+	metadata       metadata.MD
+	expectedStatus codes.Code
+	requestTimeout time.Duration
+}
+
+// GRPCTLSConfig enables TLS for gRPC calls, by default the server certificate
+// is verified against the system roots.
+type GRPCTLSConfig struct {
+	CACert             string `yaml:"CACert"`
+	ServerName         string `yaml:"ServerName"`
+	InsecureSkipVerify bool   `yaml:"InsecureSkipVerify"`
+}
+
+// newFactory connects to the target and encodes the request message.
+func (g *GRPCRequesterFactory) newFactory(params *benchParams) (bench.RequesterFactory, error) {
+	g.requestTimeout = params.RequestTimeout
+
+	var err error
+	if g.expectedStatus, err = parseStatusCode(g.ExpectedStatusCode); err != nil {
+		return nil, err
+	}
+
+	m := grpcMethodRegexp.FindStringSubmatch(g.Method)
+	if m == nil {
+		return nil, fmt.Errorf("gRPC method %q is not a full method name like package.Service/Method", g.Method)
+	}
+	service, methodName := m[1], m[2]
+	g.method = "/" + service + "/" + methodName
+
+	g.metadata = metadata.MD{}
+	for key, val := range g.Metadata {
+		g.metadata.Append(key, os.ExpandEnv(val))
+	}
+
+	transport := grpc.WithInsecure()
+	if g.TLS != nil {
+		tlsConfig := &tls.Config{
+			ServerName: g.TLS.ServerName,
+			// #nosec
+			InsecureSkipVerify: g.TLS.InsecureSkipVerify,
+		}
+		if g.TLS.CACert != "" {
+			pem, err := ioutil.ReadFile(g.TLS.CACert)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("%s: no certificates found", g.TLS.CACert)
+			}
+		}
+		transport = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+	}
+
+	connections := g.Connections
+	if connections == 0 {
+		connections = 1
+	}
+	for i := 0; i < connections; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), g.requestTimeout)
+		conn, err := grpc.DialContext(ctx, g.Target, transport, grpc.WithBlock())
+		cancel()
+		if err != nil {
+			_ = g.Close()
+			return nil, fmt.Errorf("connecting to %s: %v", g.Target, err)
+		}
+		g.conns = append(g.conns, conn)
+	}
+
+	var files *protoregistry.Files
+	if g.DescriptorSet != "" {
+		files, err = loadDescriptorSet(g.DescriptorSet)
+	} else {
+		files, err = g.reflectDescriptors(service)
+	}
+	if err != nil {
+		_ = g.Close()
+		return nil, err
+	}
+
+	if g.payload, err = encodeGRPCRequest(files, service, methodName, g.Message); err != nil {
+		_ = g.Close()
+		return nil, err
+	}
+	return g, nil
+}
+
+// Close closes the connections to the target.
+func (g *GRPCRequesterFactory) Close() error {
+	for _, conn := range g.conns {
+		_ = conn.Close()
+	}
+	g.conns = nil
+	return nil
+}
+
+func (g *GRPCRequesterFactory) validate(errs *configErrors, path string) {
+	if g.Target == "" {
+		errs.add(path+".Target", "must be set, e.g. localhost:50051")
+	}
+	if !grpcMethodRegexp.MatchString(g.Method) {
+		errs.add(path+".Method", "%q is not a full method name like package.Service/Method", g.Method)
+	}
+	if g.Message != "" && !json.Valid([]byte(g.Message)) {
+		errs.add(path+".Message", "is not valid JSON")
+	}
+	if _, err := parseStatusCode(g.ExpectedStatusCode); err != nil {
+		errs.add(path+".ExpectedStatusCode", "%v", err)
+	}
+	if g.Connections < 0 {
+		errs.add(path+".Connections", "must not be negative")
+	}
+	if g.TLS != nil && g.TLS.InsecureSkipVerify && g.TLS.CACert != "" {
+		errs.add(path+".TLS.CACert", "has no effect with InsecureSkipVerify")
+	}
+}
+
+// GetRequester returns a new Requester, called for each Benchmark connection.
+// The requesters share the factory's connections.
+func (g *GRPCRequesterFactory) GetRequester(number uint64) bench.Requester {
+	return &grpcRequester{factory: g, conn: g.conns[number%uint64(len(g.conns))]}
+}
+
+// grpcRequester implements Requester by making a unary gRPC call with the
+// pre-encoded request message.
+type grpcRequester struct {
+	factory     *GRPCRequesterFactory
+	conn        *grpc.ClientConn
+	lastDetails bench.RequestDetails
+}
+
+// Setup prepares the Requester for benchmarking.
+func (g *grpcRequester) Setup() error { return nil }
+
+// Request performs a synchronous request to the system under test.
+func (g *grpcRequester) Request() error {
+	g.lastDetails = bench.RequestDetails{Target: g.factory.method, BytesSent: int64(len(g.factory.payload))}
+
+	ctx := metadata.NewOutgoingContext(context.Background(), g.factory.metadata)
+	if g.factory.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.factory.requestTimeout)
+		defer cancel()
+	}
+
+	var resp []byte
+	err := g.conn.Invoke(ctx, g.factory.method, g.factory.payload, &resp, grpc.ForceCodec(rawCodec{}))
+	code := status.Code(err)
+
+	g.lastDetails.StatusCode = int(code)
+	g.lastDetails.HasStatus = true
+	g.lastDetails.BytesReceived = int64(len(resp))
+
+	if code != g.factory.expectedStatus {
+		return unexpectedStatusError(g.factory.expectedStatus, code)
+	}
+	return nil
+}
+
+// unexpectedStatusError reports a call which returned another status than
+// expected. The numeric codes come first, in the same shape as the errors of
+// the HTTP requester, so bench.ErrorCategory finds them.
+func unexpectedStatusError(expected, got codes.Code) error {
+	return fmt.Errorf("Expected %d (%v) got %d (%v)", expected, expected, got, got)
+}
+
+// Teardown is called upon benchmark completion.
+func (g *grpcRequester) Teardown() error { return nil }
+
+// LastRequestDetails returns the details of the last Request call.
+func (g *grpcRequester) LastRequestDetails() bench.RequestDetails { return g.lastDetails }
+
+// rawCodec sends the pre-encoded request as is and keeps the response
+// encoded, so no time is spent on protobuf encoding during the benchmark.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return v.([]byte), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*[]byte) = append([]byte(nil), data...)
+	return nil
+}
+
+// Name is the content subtype, the payload is protobuf after all.
+func (rawCodec) Name() string { return "proto" }
+
+// parseStatusCode parses a gRPC status code given by name, e.g. NOT_FOUND, or
+// number, an empty string is OK.
+func parseStatusCode(s string) (codes.Code, error) {
+	var code codes.Code
+	if s == "" {
+		return codes.OK, nil
+	}
+	if err := code.UnmarshalJSON([]byte(s)); err == nil {
+		return code, nil
+	}
+	if err := code.UnmarshalJSON([]byte(`"` + strings.ToUpper(s) + `"`)); err != nil {
+		return 0, fmt.Errorf("%q is not a gRPC status code, e.g. OK or NOT_FOUND", s)
+	}
+	return code, nil
+}
+
+// loadDescriptorSet reads a FileDescriptorSet, as written by
+// protoc --include_imports --descriptor_set_out.
+func loadDescriptorSet(file string) (*protoregistry.Files, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return files, nil
+}
+
+// reflectDescriptors fetches the file defining the service, along with all
+// of its dependencies, by server reflection.
+func (g *GRPCRequesterFactory) reflectDescriptors(service string) (*protoregistry.Files, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), g.requestTimeout)
+	defer cancel()
+
+	stream, err := rpb.NewServerReflectionClient(g.conns[0]).ServerReflectionInfo(metadata.NewOutgoingContext(ctx, g.metadata))
+	if err != nil {
+		return nil, fmt.Errorf("server reflection: %v", err)
+	}
+	defer func() { _ = stream.CloseSend() }()
+
+	var (
+		set     descriptorpb.FileDescriptorSet
+		fetched = make(map[string]bool)
+		pending []*rpb.ServerReflectionRequest
+	)
+	pending = append(pending, &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service},
+	})
+
+	for len(pending) > 0 {
+		req := pending[0]
+		pending = pending[1:]
+
+		if err := stream.Send(req); err != nil {
+			return nil, fmt.Errorf("server reflection: %v", err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, fmt.Errorf("server reflection: %v", err)
+		}
+		if errResp := resp.GetErrorResponse(); errResp != nil {
+			return nil, fmt.Errorf("server reflection: %s", errResp.ErrorMessage)
+		}
+
+		for _, raw := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			file := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(raw, file); err != nil {
+				return nil, fmt.Errorf("server reflection: %v", err)
+			}
+			if fetched[file.GetName()] {
+				continue
+			}
+			fetched[file.GetName()] = true
+			set.File = append(set.File, file)
+
+			for _, dep := range file.GetDependency() {
+				if fetched[dep] {
+					continue
+				}
+				// Well-known types are linked in anyway
+				if wellKnown, err := protoregistry.GlobalFiles.FindFileByPath(dep); err == nil {
+					fetched[dep] = true
+					set.File = append(set.File, protodesc.ToFileDescriptorProto(wellKnown))
+					continue
+				}
+				pending = append(pending, &rpb.ServerReflectionRequest{
+					MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+				})
+			}
+		}
+	}
+
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("server reflection: %v", err)
+	}
+	return files, nil
+}
+
+// encodeGRPCRequest encodes the JSON request as the input message of the
+// method.
+func encodeGRPCRequest(files *protoregistry.Files, service, methodName, request string) ([]byte, error) {
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("gRPC service %s: %v", service, err)
+	}
+	serviceDesc, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a gRPC service", service)
+	}
+	method := serviceDesc.Methods().ByName(protoreflect.Name(methodName))
+	if method == nil {
+		return nil, fmt.Errorf("gRPC service %s has no method %s", service, methodName)
+	}
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return nil, errors.New("only unary gRPC methods are supported, " + string(method.FullName()) + " is streaming")
+	}
+
+	msg := dynamicpb.NewMessage(method.Input())
+	if strings.TrimSpace(request) != "" {
+		if err := protojson.Unmarshal([]byte(request), msg); err != nil {
+			return nil, fmt.Errorf("gRPC request is not a valid %s: %v", method.Input().FullName(), err)
+		}
+	}
+	return proto.Marshal(msg)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+
+	"labench/bench"
+)
+
+func TestUnexpectedStatusErrorCategory(t *testing.T) {
+	tests := []struct {
+		expected, got codes.Code
+		want          string
+	}{
+		{codes.OK, codes.NotFound, "status_5"},
+		{codes.OK, codes.DeadlineExceeded, "status_4"},
+		{codes.NotFound, codes.OK, "status_0"},
+		{codes.OK, codes.Unauthenticated, "status_16"},
+	}
+
+	for _, tt := range tests {
+		err := unexpectedStatusError(tt.expected, tt.got)
+		if got := bench.ErrorCategory(err); got != tt.want {
+			t.Errorf("ErrorCategory(%q) = %q, want %q", err, got, tt.want)
+		}
+	}
+}
+
+func TestParseStatusCode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    codes.Code
+		wantErr bool
+	}{
+		{"", codes.OK, false},
+		{"OK", codes.OK, false},
+		{"NOT_FOUND", codes.NotFound, false},
+		{"not_found", codes.NotFound, false},
+		{"5", codes.NotFound, false},
+		{"NotFound", 0, true},
+		{"teapot", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseStatusCode(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseStatusCode(%q) = %v, %v, want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -1,14 +1,15 @@
 package main
 
 import (
-	"errors"
+	"context"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"math"
-	"net/http"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"labench/bench"
@@ -19,19 +20,32 @@ import (
 type benchParams struct {
 	RequestRatePerSec uint64        `yaml:"RequestRatePerSec"`
 	Clients           uint64        `yaml:"Clients"`
+	ThinkTime         time.Duration `yaml:"ThinkTime"`
 	Duration          time.Duration `yaml:"Duration"`
+	MaxRequests       uint64        `yaml:"MaxRequests"`
 	BaseLatency       time.Duration `yaml:"BaseLatency"`
 	RequestTimeout    time.Duration `yaml:"RequestTimeout"`
 	ReuseConnections  bool          `yaml:"ReuseConnections"`
 	DontLinger        bool          `yaml:"DontLinger"`
 	OutputJSON        bool          `yaml:"OutputJSON"`
 	TightTicker       bool          `yaml:"TightTicker"`
+	MetricsListenAddr string        `yaml:"MetricsListenAddr"`
+	ControlListenAddr string        `yaml:"ControlListenAddr"`
+	Agents            []string      `yaml:"Agents"`
+	RawLog            string        `yaml:"RawLog"`
+	RawLogFormat      string        `yaml:"RawLogFormat"`
 }
 
 type config struct {
-	Params   benchParams         `yaml:",inline"`
-	Protocol string              `yaml:"Protocol"`
-	Request  WebRequesterFactory `yaml:"Request"`
+	Params   benchParams            `yaml:",inline"`
+	Protocol string                 `yaml:"Protocol"`
+	Sweep    *sweepParams           `yaml:"Sweep"`
+	Search   *searchParams          `yaml:"Search"`
+	Abort    *bench.AbortConditions `yaml:"Abort"`
+
+	// RawRequest is decoded into Request, whose type depends on Protocol
+	RawRequest yaml.MapSlice   `yaml:"Request"`
+	Request    requesterConfig `yaml:"-"`
 }
 
 func maybePanic(err error) {
@@ -40,59 +54,176 @@ func maybePanic(err error) {
 	}
 }
 
-func assert(cond bool, err string) {
-	if !cond {
-		log.Panic(errors.New(err))
+// exitAborted is the exit code when a benchmark was aborted by one of its
+// abort conditions.
+const exitAborted = 3
+
+// exitOnError reports a user facing error, such as an invalid config, and
+// exits without a stack trace.
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
 }
 
+const usage = `Usage: %[1]s [run] [flags] [config.yaml]
+       %[1]s report [flags] <raw log file>
+       %[1]s merge [flags] <summary.json>...
+       %[1]s agent [flags]
+       %[1]s serve [flags]
+
+The default config file name is: labench.yaml
+Run "%[1]s <command> -h" for the list of flags of a command.
+`
+
 func main() {
-	configFile := "labench.yaml"
-	if len(os.Args) > 1 {
-		assert(len(os.Args) == 2, fmt.Sprintf("Usage: %s [config.yaml]\n\tThe default config file name is: %s", os.Args[0], configFile))
-		configFile = os.Args[1]
+	// Without a subcommand the arguments are those of run, which keeps
+	// "labench config.yaml" working
+	args := os.Args[1:]
+	command := "run"
+	if len(args) > 0 {
+		switch args[0] {
+		case "run", "report", "merge", "agent", "serve", "help", "-h", "-help", "--help":
+			command, args = args[0], args[1:]
+		}
+	}
+
+	switch command {
+	case "run":
+		runMain(args)
+	case "report":
+		reportMain(args)
+	case "merge":
+		mergeMain(args)
+	case "agent":
+		agentMain(args)
+	case "serve":
+		serveMain(args)
+	default:
+		fmt.Printf(usage, os.Args[0])
 	}
+}
 
-	configBytes, err := ioutil.ReadFile(configFile)
-	maybePanic(err)
+// runMain implements the run subcommand, which runs the benchmark described
+// by the config file. Flags override the corresponding config fields.
+func runMain(args []string) {
+	var (
+		flags     = flag.NewFlagSet("run", flag.ExitOnError)
+		overrides setFlag
+		outDir    string
+	)
 
-	var conf config
-	err = yaml.Unmarshal(configBytes, &conf)
-	maybePanic(err)
+	// Shortcuts for the most commonly swept parameters, equivalent to --set
+	flags.Func("rate", "override RequestRatePerSec", overrideFunc(&overrides, "RequestRatePerSec"))
+	flags.Func("duration", "override Duration, e.g. 30s", overrideFunc(&overrides, "Duration"))
+	flags.Func("clients", "override Clients", overrideFunc(&overrides, "Clients"))
+	flags.Func("protocol", "override Protocol, one of "+strings.Join(protocolNames(), ", "), overrideFunc(&overrides, "Protocol"))
+	flags.Func("url", "override Request.URL, replaces Request.URLs from the config", func(v string) error {
+		overrides = append(overrides, configOverride{"Request.URLs", nil}, configOverride{"Request.URL", v})
+		return nil
+	})
+	flags.Var(&overrides, "set", "override any config field, e.g. --set Request.Headers.X=Y (can be repeated)")
+	flags.StringVar(&outDir, "out", "out", "directory to write the results to")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [run] [flags] [config.yaml]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
 
-	// fmt.Printf("%+v\n", conf)
-	fmt.Println("timeStart =", time.Now().UTC().Add(-5*time.Second).Truncate(time.Second))
+	_ = flags.Parse(args)
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
 
-	if conf.Request.ExpectedHTTPStatusCode == 0 {
-		conf.Request.ExpectedHTTPStatusCode = 200
+	configFile := "labench.yaml"
+	if flags.NArg() == 1 {
+		configFile = flags.Arg(0)
 	}
 
-	if conf.Request.HTTPMethod == "" {
-		if conf.Request.Body == "" {
-			conf.Request.HTTPMethod = http.MethodGet
-		} else {
-			conf.Request.HTTPMethod = http.MethodPost
+	runs, coolDown, err := loadRuns(configFile, overrides)
+	exitOnError(err)
+
+	var (
+		results bench.SummaryTable
+		aborted bool
+	)
+	for i, run := range runs {
+		runOutDir := outDir
+		if run.Name != "" {
+			runOutDir = path.Join(outDir, run.Name)
+			if i > 0 && coolDown > 0 {
+				fmt.Printf("\nCooling down for %v\n", coolDown)
+				time.Sleep(coolDown)
+			}
+			fmt.Printf("\n=== Run %d/%d: %s\n", i+1, len(runs), run.Name)
 		}
-	}
 
-	if conf.Protocol == "" {
-		conf.Protocol = "HTTP/1.1"
+		if run.Config.Search != nil {
+			// Aborted probes just fail the SLO
+			searchResults, err := runSearch(run, runOutDir)
+			exitOnError(err)
+
+			results = append(results, searchResults...)
+			continue
+		}
+
+		if run.Config.Sweep != nil {
+			sweepResults, err := runSweep(run, runOutDir)
+			exitOnError(err)
+
+			for _, r := range sweepResults {
+				aborted = aborted || r.Summary.Aborted
+			}
+			results = append(results, sweepResults...)
+			continue
+		}
+
+		summary, err := runBenchmark(run.Config, run.ConfigBytes, runOutDir, time.Time{})
+		exitOnError(err)
+
+		aborted = aborted || summary.Aborted
+		results = append(results, bench.NamedSummary{Name: run.Name, Summary: summary})
 	}
 
-	fmt.Println("Protocol:", conf.Protocol)
+	if len(runs) > 1 {
+		fmt.Println("\nAll runs:")
+		fmt.Println(results)
+
+		err = results.WriteCSV(path.Join(outDir, "runs.csv"))
+		exitOnError(err)
+	}
 
-	switch conf.Protocol {
-	case "HTTP/2":
-		initHTTP2Client(conf.Params.RequestTimeout, conf.Params.DontLinger)
+	if aborted {
+		fmt.Fprintln(os.Stderr, "Error: the benchmark was aborted, see the summary for the reason")
+		os.Exit(exitAborted)
+	}
+}
 
-	default:
-		initHTTPClient(conf.Params.ReuseConnections, conf.Params.RequestTimeout, conf.Params.DontLinger)
+// runBenchmark runs a single benchmark and writes its results to outDir. A
+// non-zero startAt delays the start of the run until then, after the setup.
+func runBenchmark(conf *config, configBytes []byte, outDir string, startAt time.Time) (*bench.Summary, error) {
+	if len(conf.Params.Agents) > 0 {
+		return runDistributed(conf, configBytes, outDir)
 	}
 
+	// fmt.Printf("%+v\n", conf)
+	fmt.Println("timeStart =", time.Now().UTC().Add(-5*time.Second).Truncate(time.Second))
+
+	fmt.Println("Protocol:", conf.Protocol)
+
 	if conf.Params.RequestTimeout == 0 {
 		conf.Params.RequestTimeout = 10 * time.Second
 	}
 
+	factory, err := conf.Request.newFactory(&conf.Params)
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := factory.(io.Closer); ok {
+		defer closer.Close()
+	}
+
 	if conf.Params.Clients == 0 {
 		clients := conf.Params.RequestRatePerSec * uint64(math.Ceil(conf.Params.RequestTimeout.Seconds()))
 		clients += clients / 5 // add 20%
@@ -100,17 +231,92 @@ func main() {
 		fmt.Println("Clients:", clients)
 	}
 
-	benchmark := bench.NewBenchmark(&conf.Request, conf.Params.RequestRatePerSec, conf.Params.Clients, conf.Params.Duration, conf.Params.BaseLatency)
-	summary, err := benchmark.Run(conf.Params.OutputJSON, conf.Params.TightTicker)
-	maybePanic(err)
+	benchmark, err := bench.New(bench.Options{
+		Factory:     factory,
+		RequestRate: conf.Params.RequestRatePerSec,
+		Connections: conf.Params.Clients,
+		Duration:    conf.Params.Duration,
+		MaxRequests: conf.Params.MaxRequests,
+		BaseLatency: conf.Params.BaseLatency,
+		ThinkTime:   conf.Params.ThinkTime,
+		StartAt:     startAt,
+		Abort:       conf.Abort,
+		TightTicker: conf.Params.TightTicker,
+		OutputJSON:  conf.Params.OutputJSON,
+		Output:      os.Stdout,
+		Logger:      log.New(os.Stderr, "", log.LstdFlags),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Params.MetricsListenAddr != "" {
+		if err := benchmark.ServeMetrics(conf.Params.MetricsListenAddr); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Serving metrics on http://%s/metrics\n", conf.Params.MetricsListenAddr)
+	}
+
+	if conf.Params.ControlListenAddr != "" {
+		if err := benchmark.ServeControl(conf.Params.ControlListenAddr); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Serving control endpoint on http://%s/status\n", conf.Params.ControlListenAddr)
+	}
+
+	if conf.Params.RawLog != "" {
+		if err := benchmark.LogRequests(conf.Params.RawLog, bench.RawLogFormat(conf.Params.RawLogFormat)); err != nil {
+			return nil, err
+		}
+	}
+
+	summary, err := benchmark.RunContext(context.Background())
+	if err != nil {
+		return nil, err
+	}
 
 	fmt.Println("timeEnd   =", time.Now().UTC().Add(5*time.Second).Round(time.Second))
 
 	fmt.Println(summary)
 
-	err = os.MkdirAll("out", os.ModeDir|os.ModePerm)
-	maybePanic(err)
+	if err := writeResults(summary, configBytes, outDir); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// writeResults writes the latency distribution, the interval statistics, the
+// HTML report and the encoded summary, which can be merged later, of a
+// benchmark to outDir.
+func writeResults(summary *bench.Summary, configBytes []byte, outDir string) error {
+	err := os.MkdirAll(outDir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		return err
+	}
 
-	err = summary.GenerateLatencyDistribution(bench.Logarithmic, path.Join("out", "res.hgrm"))
-	maybePanic(err)
+	err = summary.GenerateLatencyDistribution(bench.Logarithmic, path.Join(outDir, "res.hgrm"))
+	if err != nil {
+		return err
+	}
+
+	err = summary.WriteIntervalsCSV(path.Join(outDir, "intervals.csv"))
+	if err != nil {
+		return err
+	}
+
+	err = summary.GenerateHTMLReport(string(configBytes), path.Join(outDir, "report.html"))
+	if err != nil {
+		return err
+	}
+
+	return summary.SaveEncoded(path.Join(outDir, "summary.json"))
+}
+
+// overrideFunc returns a flag handler which overrides the config field at the
+// given path with the flag value.
+func overrideFunc(overrides *setFlag, path string) func(string) error {
+	return func(v string) error {
+		*overrides = append(*overrides, configOverride{path, parseYAMLValue(v)})
+		return nil
+	}
 }
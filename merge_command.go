@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"labench/bench"
+)
+
+// mergeMain combines the summaries saved by several runs, typically on
+// different machines loading the same service at the same time, into one.
+func mergeMain(args []string) {
+	var (
+		flags  = flag.NewFlagSet("merge", flag.ExitOnError)
+		outDir string
+	)
+
+	flags.StringVar(&outDir, "out", path.Join("out", "merged"), "directory to write the results to")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s merge [flags] <summary.json>...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var (
+		table     bench.SummaryTable
+		summaries []*bench.Summary
+	)
+	for _, file := range flags.Args() {
+		summary, err := bench.LoadEncodedSummary(file)
+		exitOnError(err)
+
+		table = append(table, bench.NamedSummary{Name: file, Summary: summary})
+		summaries = append(summaries, summary)
+	}
+
+	merged, err := bench.MergeSummaries(summaries...)
+	exitOnError(err)
+
+	fmt.Println("Merged runs:")
+	fmt.Println(table)
+	fmt.Println(merged)
+
+	source := "# Merged from\n# " + strings.Join(flags.Args(), "\n# ") + "\n"
+	exitOnError(writeResults(merged, []byte(source), outDir))
+	exitOnError(table.WriteCSV(path.Join(outDir, "merged.csv")))
+}
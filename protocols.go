@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"labench/bench"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// defaultProtocol is used when the config doesn't set Protocol.
+const defaultProtocol = "HTTP/1.1"
+
+// requesterConfig is the Request block of a protocol. Every protocol has its
+// own config type, which is the YAML schema of the block.
+type requesterConfig interface {
+	// validate reports invalid values and field combinations, path is the
+	// path of the Request block.
+	validate(errs *configErrors, path string)
+
+	// newFactory sets up the RequesterFactory for a benchmark, e.g. connects
+	// to the target. Factories holding resources which must be released
+	// after the benchmark implement io.Closer.
+	newFactory(params *benchParams) (bench.RequesterFactory, error)
+}
+
+// exhaustibleConfig is implemented by requester configs which can run out of
+// requests, which ends the benchmark without a Duration.
+type exhaustibleConfig interface {
+	exhaustible() bool
+}
+
+// protocols maps the protocol names to the constructors of their empty
+// requester configs.
+var protocols = make(map[string]func() requesterConfig)
+
+// registerProtocol makes a protocol available to the Protocol setting, its
+// Request block is decoded into the config returned by newConfig. It must be
+// called from init.
+func registerProtocol(name string, newConfig func() requesterConfig) {
+	if _, ok := protocols[name]; ok {
+		panic("protocol " + name + " registered twice")
+	}
+	protocols[name] = newConfig
+	addKnownFields(reflect.TypeOf(newConfig()))
+}
+
+// protocolNames returns the names of the registered protocols, sorted.
+func protocolNames() []string {
+	names := make([]string, 0, len(protocols))
+	for name := range protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// decodeRequest decodes the Request block into the config type of the
+// protocol.
+func (c *config) decodeRequest() configErrors {
+	if c.Protocol == "" {
+		c.Protocol = defaultProtocol
+	}
+	newConfig, ok := protocols[c.Protocol]
+	if !ok {
+		return configErrors{{"Protocol", fmt.Sprintf("unknown protocol %q, supported protocols are %s", c.Protocol, strings.Join(protocolNames(), ", "))}}
+	}
+	c.Request = newConfig()
+
+	data, err := yaml.Marshal(c.RawRequest)
+	if err != nil {
+		return configErrors{{"Request", err.Error()}}
+	}
+
+	var errs configErrors
+	if err := yaml.UnmarshalStrict(data, c.Request); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return configErrors{{"Request", err.Error()}}
+		}
+		// Line numbers refer to the Request block on its own
+		for _, e := range typeErr.Errors {
+			errs.add("Request", "%s", yamlLineRegexp.ReplaceAllString(describeYAMLError(e), ""))
+		}
+	}
+	return errs
+}
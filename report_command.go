@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"labench/bench"
+)
+
+// reportMain implements the report subcommand, which rebuilds the results of
+// a run from its raw request log (see RawLog in full_config.yaml).
+func reportMain(args []string) {
+	var (
+		flags  = flag.NewFlagSet("report", flag.ExitOnError)
+		filter bench.RequestLogFilter
+		outDir string
+	)
+
+	flags.DurationVar(&filter.From, "from", 0, "only include requests sent at least this long after the start of the run, e.g. 1m")
+	flags.DurationVar(&filter.To, "to", 0, "only include requests sent before this long after the start of the run, 0 means until the end")
+	flags.StringVar(&filter.Target, "target", "", "only include requests sent to this target URL")
+	flags.DurationVar(&filter.BaseLatency, "base-latency", 0, "subtracted from every latency measurement, see BaseLatency in the config")
+	flags.StringVar(&outDir, "out", path.Join("out", "report"), "directory to write the results to")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s report [flags] <raw log file>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	logFile := flags.Arg(0)
+
+	summary, err := bench.SummarizeRequestLog(logFile, filter)
+	exitOnError(err)
+
+	fmt.Println(summary)
+
+	source := fmt.Sprintf("# Rebuilt from raw request log\nRawLog: %s\nFrom: %v\nTo: %v\nTarget: %q\nBaseLatency: %v\n",
+		logFile, filter.From, filter.To, filter.Target, filter.BaseLatency)
+	exitOnError(writeResults(summary, []byte(source), outDir))
+}
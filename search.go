@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"labench/bench"
+)
+
+// searchParams describes a search for the highest request rate at which the
+// system under test still meets the SLO. Short probes are run starting at
+// MinRate, doubling the rate until the SLO is violated, after which the
+// capacity is narrowed down by binary search.
+type searchParams struct {
+	MinRate       uint64        `yaml:"MinRate"`
+	MaxRate       uint64        `yaml:"MaxRate"`
+	ProbeDuration time.Duration `yaml:"ProbeDuration"`
+	CoolDown      time.Duration `yaml:"CoolDown"`
+	MaxProbes     int           `yaml:"MaxProbes"`
+
+	// Precision is the relative difference, in percent, between the highest
+	// passing and the lowest failing rate at which the search stops.
+	Precision float64   `yaml:"Precision"`
+	SLO       sloParams `yaml:"SLO"`
+}
+
+// sloParams is the service level objective probes are checked against.
+type sloParams struct {
+	// Latency maps percentiles to the maximum latency at that percentile,
+	// e.g. 99: 200ms.
+	Latency map[float64]time.Duration `yaml:"Latency"`
+
+	// MaxErrorRate is the maximum percentage of failed requests, if set.
+	MaxErrorRate *float64 `yaml:"MaxErrorRate"`
+
+	// MinThroughputRatio is the minimum ratio, in percent, of the measured
+	// throughput to the request rate, it defaults to 95. It fails probes
+	// where requests didn't even complete at the requested rate.
+	MinThroughputRatio float64 `yaml:"MinThroughputRatio"`
+}
+
+const (
+	defaultSearchMaxProbes          = 20
+	defaultSearchPrecision          = 5
+	defaultSearchMinThroughputRatio = 95
+)
+
+func (s *searchParams) validate(errs *configErrors, path string) {
+	if s.MinRate == 0 {
+		errs.add(path+".MinRate", "must be positive")
+	}
+	if s.MaxRate != 0 && s.MaxRate < s.MinRate {
+		errs.add(path+".MaxRate", "must not be less than MinRate")
+	}
+	validateDuration(errs, path+".ProbeDuration", s.ProbeDuration, false)
+	if s.CoolDown < 0 {
+		errs.add(path+".CoolDown", "must not be negative")
+	}
+	if s.MaxProbes < 0 {
+		errs.add(path+".MaxProbes", "must not be negative")
+	}
+	if s.Precision < 0 || s.Precision >= 100 {
+		errs.add(path+".Precision", "must be between 0 and 100")
+	}
+
+	if len(s.SLO.Latency) == 0 && s.SLO.MaxErrorRate == nil {
+		errs.add(path+".SLO", "either Latency or MaxErrorRate must be set")
+	}
+	for p, d := range s.SLO.Latency {
+		if p <= 0 || p > 100 {
+			errs.add(fmt.Sprintf("%s.SLO.Latency[%g]", path, p), "percentile must be between 0 and 100")
+		}
+		validateDuration(errs, fmt.Sprintf("%s.SLO.Latency[%g]", path, p), d, true)
+	}
+	if s.SLO.MaxErrorRate != nil && (*s.SLO.MaxErrorRate < 0 || *s.SLO.MaxErrorRate > 100) {
+		errs.add(path+".SLO.MaxErrorRate", "must be between 0 and 100")
+	}
+	if s.SLO.MinThroughputRatio < 0 || s.SLO.MinThroughputRatio > 100 {
+		errs.add(path+".SLO.MinThroughputRatio", "must be between 0 and 100")
+	}
+}
+
+// check returns whether the probe summary meets the SLO, and if it doesn't,
+// the violations.
+func (slo *sloParams) check(s *bench.Summary) (bool, string) {
+	var violations []string
+
+	if s.Aborted {
+		violations = append(violations, "aborted: "+s.AbortReason)
+	}
+
+	percentiles := make([]float64, 0, len(slo.Latency))
+	for p := range slo.Latency {
+		percentiles = append(percentiles, p)
+	}
+	sort.Float64s(percentiles)
+	for _, p := range percentiles {
+		latency := time.Duration(s.SuccessHistogram.ValueAtQuantile(p))
+		if latency > slo.Latency[p] {
+			violations = append(violations, fmt.Sprintf("p%g %v > %v", p, latency, slo.Latency[p]))
+		}
+	}
+
+	if slo.MaxErrorRate != nil && s.ErrorRate() > *slo.MaxErrorRate {
+		violations = append(violations, fmt.Sprintf("errors %.3f%% > %g%%", s.ErrorRate(), *slo.MaxErrorRate))
+	}
+
+	minRatio := slo.MinThroughputRatio
+	if minRatio == 0 {
+		minRatio = defaultSearchMinThroughputRatio
+	}
+	if ratio := s.Throughput * 100 / s.RequestRate; ratio < minRatio {
+		violations = append(violations, fmt.Sprintf("throughput %.1f%% of rate < %g%%", ratio, minRatio))
+	}
+
+	return len(violations) == 0, strings.Join(violations, ", ")
+}
+
+// nextRate returns the rate of the next probe given the highest passing and
+// the lowest failing rate so far, or false when the search is done. The rate
+// is doubled until the SLO is violated, then the capacity is bisected.
+func (s *searchParams) nextRate(rate, passed, failed uint64, precision float64) (uint64, bool) {
+	if failed == 0 {
+		if s.MaxRate > 0 && rate >= s.MaxRate {
+			return 0, false
+		}
+		rate *= 2
+		if s.MaxRate > 0 && rate > s.MaxRate {
+			rate = s.MaxRate
+		}
+		return rate, true
+	}
+
+	if passed == 0 || float64(failed-passed)*100/float64(passed) <= precision {
+		return 0, false
+	}
+	next := passed + (failed-passed)/2
+	return next, next != passed
+}
+
+// runSearch probes the system under test at increasing request rates to find
+// its capacity, writing the results of each probe to its own directory and
+// the evidence of all probes to outDir.
+func runSearch(run benchmarkRun, outDir string) (bench.SummaryTable, error) {
+	var (
+		search    = run.Config.Search
+		maxProbes = search.MaxProbes
+		precision = search.Precision
+		passed    uint64 // highest rate which met the SLO
+		failed    uint64 // lowest rate which didn't
+		rate      = search.MinRate
+		results   bench.SummaryTable
+		verdicts  []string
+	)
+	if maxProbes == 0 {
+		maxProbes = defaultSearchMaxProbes
+	}
+	if precision == 0 {
+		precision = defaultSearchPrecision
+	}
+
+	for probe := 1; probe <= maxProbes; probe++ {
+		if probe > 1 && search.CoolDown > 0 {
+			fmt.Printf("\nCooling down for %v\n", search.CoolDown)
+			time.Sleep(search.CoolDown)
+		}
+
+		fmt.Printf("\n=== Probe %d: %d req/s\n", probe, rate)
+
+		overrides := []configOverride{{"Search", nil}, {"RequestRatePerSec", rate}}
+		if search.ProbeDuration > 0 {
+			overrides = append(overrides, configOverride{"Duration", search.ProbeDuration.String()})
+		}
+		conf, configBytes, errs := decodeConfig(run.ConfigBytes, overrides)
+		if len(errs) > 0 {
+			return nil, errs
+		}
+
+		name := fmt.Sprintf("probe-%d-rate-%d", probe, rate)
+		summary, err := runBenchmark(conf, configBytes, path.Join(outDir, name), time.Time{})
+		if err != nil {
+			return nil, err
+		}
+
+		ok, violations := search.SLO.check(summary)
+		verdict := "PASS"
+		if ok {
+			passed = rate
+		} else {
+			verdict = "FAIL: " + violations
+			failed = rate
+		}
+		fmt.Printf("Probe %d at %d req/s: %s\n", probe, rate, verdict)
+		verdicts = append(verdicts, fmt.Sprintf("%s %s", name, verdict))
+
+		if run.Name != "" {
+			name = run.Name + "/" + name
+		}
+		results = append(results, bench.NamedSummary{Name: name, Summary: summary})
+
+		next, more := search.nextRate(rate, passed, failed, precision)
+		if !more {
+			break
+		}
+		rate = next
+	}
+
+	fmt.Println("\nSearch probes:")
+	fmt.Println(results)
+	for _, v := range verdicts {
+		fmt.Println(v)
+	}
+
+	switch {
+	case passed == 0:
+		fmt.Printf("\nCapacity: the SLO was not met even at the minimum rate of %d req/s\n", search.MinRate)
+	case failed == 0:
+		fmt.Printf("\nCapacity: at least %d req/s, the SLO was met at every probed rate\n", passed)
+	default:
+		fmt.Printf("\nCapacity: %d req/s, the SLO was violated at %d req/s\n", passed, failed)
+	}
+
+	if err := results.WriteCSV(path.Join(outDir, "search.csv")); err != nil {
+		return nil, err
+	}
+
+	// The report charts results by rate, while probes aren't run in order
+	byRate := append(bench.SummaryTable(nil), results...)
+	sort.SliceStable(byRate, func(i, j int) bool { return byRate[i].Summary.RequestRate < byRate[j].Summary.RequestRate })
+	report := string(run.ConfigBytes) + "\n# Probes\n# " + strings.Join(verdicts, "\n# ") + "\n# Capacity: " + strconv.FormatUint(passed, 10) + " req/s\n"
+	if err := byRate.GenerateSweepReport(report, path.Join(outDir, "search.html")); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// latencyDistribution is the distribution of the latency the calibration
+// server adds to every response.
+type latencyDistribution interface {
+	sample() time.Duration
+}
+
+type fixedLatency time.Duration
+
+func (l fixedLatency) sample() time.Duration { return time.Duration(l) }
+
+type uniformLatency struct{ min, max time.Duration }
+
+func (l uniformLatency) sample() time.Duration {
+	return l.min + time.Duration(rand.Int63n(int64(l.max-l.min)+1))
+}
+
+// lognormalLatency has the given median, sigma is the standard deviation of
+// the underlying normal distribution.
+type lognormalLatency struct {
+	median time.Duration
+	sigma  float64
+}
+
+func (l lognormalLatency) sample() time.Duration {
+	return time.Duration(float64(l.median) * math.Exp(l.sigma*rand.NormFloat64()))
+}
+
+// bimodalLatency is usually fast, but slow for a fraction of the responses.
+type bimodalLatency struct {
+	fast, slow time.Duration
+	slowRatio  float64
+}
+
+func (l bimodalLatency) sample() time.Duration {
+	if rand.Float64() < l.slowRatio {
+		return l.slow
+	}
+	return l.fast
+}
+
+// parseLatency parses a latency distribution spec:
+//
+//	fixed:<latency>
+//	uniform:<min>,<max>
+//	lognormal:<median>,<sigma>
+//	bimodal:<fast>,<slow>,<slow percentage>
+func parseLatency(spec string) (latencyDistribution, error) {
+	kind, params := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		kind, params = spec[:i], spec[i+1:]
+	}
+	args := strings.Split(params, ",")
+
+	duration := func(i int) (time.Duration, error) {
+		d, err := time.ParseDuration(strings.TrimSpace(args[i]))
+		if err == nil && d < 0 {
+			err = errors.New("latency must not be negative")
+		}
+		return d, err
+	}
+	number := func(i int) (float64, error) {
+		return strconv.ParseFloat(strings.TrimSpace(args[i]), 64)
+	}
+
+	var (
+		dist latencyDistribution
+		err  error
+	)
+	switch {
+	case kind == "fixed" && len(args) == 1:
+		var d time.Duration
+		d, err = duration(0)
+		dist = fixedLatency(d)
+
+	case kind == "uniform" && len(args) == 2:
+		var l uniformLatency
+		if l.min, err = duration(0); err == nil {
+			l.max, err = duration(1)
+		}
+		if err == nil && l.max < l.min {
+			err = errors.New("max must not be less than min")
+		}
+		dist = l
+
+	case kind == "lognormal" && len(args) == 2:
+		var l lognormalLatency
+		if l.median, err = duration(0); err == nil {
+			l.sigma, err = number(1)
+		}
+		if err == nil && l.sigma < 0 {
+			err = errors.New("sigma must not be negative")
+		}
+		dist = l
+
+	case kind == "bimodal" && len(args) == 3:
+		var l bimodalLatency
+		if l.fast, err = duration(0); err == nil {
+			if l.slow, err = duration(1); err == nil {
+				l.slowRatio, err = number(2)
+				l.slowRatio /= 100
+			}
+		}
+		if err == nil && (l.slowRatio < 0 || l.slowRatio > 1) {
+			err = errors.New("slow percentage must be between 0 and 100")
+		}
+		dist = l
+
+	default:
+		return nil, fmt.Errorf("%s: expected fixed:<latency>, uniform:<min>,<max>, lognormal:<median>,<sigma> or bimodal:<fast>,<slow>,<slow %%>", spec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", spec, err)
+	}
+	return dist, nil
+}
+
+// calibrationServer is a target with a known behavior, to validate the
+// benchmark pipeline and measure the generator's own added latency.
+type calibrationServer struct {
+	latency     latencyDistribution
+	stallEvery  time.Duration
+	stallFor    time.Duration
+	errorRatio  float64
+	errorStatus int
+	body        []byte
+	events      int
+	eventGap    time.Duration
+	start       time.Time
+}
+
+func (s *calibrationServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	arrived := time.Now()
+	delay := s.latency.sample()
+
+	// Requests arriving during a stall are held until it's over
+	if s.stallEvery > 0 {
+		if sinceStall := arrived.Sub(s.start) % s.stallEvery; sinceStall < s.stallFor {
+			delay += s.stallFor - sinceStall
+		}
+	}
+	time.Sleep(delay)
+
+	// The added latency, so the generator's own latency can be told apart
+	w.Header().Set("Server-Timing", fmt.Sprintf("app;dur=%.3f", float64(time.Since(arrived))/float64(time.Millisecond)))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(s.body)))
+
+	status := http.StatusOK
+	if s.errorRatio > 0 && rand.Float64() < s.errorRatio {
+		status = s.errorStatus
+	}
+
+	if s.events > 0 && status == http.StatusOK {
+		s.stream(w)
+		return
+	}
+
+	w.WriteHeader(status)
+	_, _ = w.Write(s.body)
+}
+
+// stream sends the response as Server-Sent Events, each carrying the body.
+func (s *calibrationServer) stream(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	for i := 0; i < s.events; i++ {
+		if i > 0 {
+			time.Sleep(s.eventGap)
+		}
+		fmt.Fprintf(w, "id: %d\ndata: %s\n\n", i, s.body)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// serveMain starts the calibration server, which serves HTTP/1.1 and
+// HTTP/2 over cleartext (h2c) on the same address.
+func serveMain(args []string) {
+	var (
+		flags       = flag.NewFlagSet("serve", flag.ExitOnError)
+		listen      string
+		latencySpec string
+		errorRate   float64
+		size        int
+		srv         = &calibrationServer{}
+	)
+
+	flags.StringVar(&listen, "listen", "localhost:8080", "address to listen on")
+	flags.StringVar(&latencySpec, "latency", "fixed:0s", "latency distribution: fixed:<latency>, uniform:<min>,<max>,\nlognormal:<median>,<sigma> or bimodal:<fast>,<slow>,<slow %>")
+	flags.DurationVar(&srv.stallEvery, "stall-every", 0, "stall all responses periodically, e.g. every 10s")
+	flags.DurationVar(&srv.stallFor, "stall-for", 0, "how long each periodic stall lasts, e.g. 500ms")
+	flags.Float64Var(&errorRate, "error-rate", 0, "percentage of responses with the error status")
+	flags.IntVar(&srv.errorStatus, "error-status", http.StatusServiceUnavailable, "status code of injected errors")
+	flags.IntVar(&size, "size", 0, "response body size in bytes")
+	flags.IntVar(&srv.events, "events", 0, "stream successful responses as this many Server-Sent Events, each carrying the body")
+	flags.DurationVar(&srv.eventGap, "event-gap", 0, "time between streamed events, e.g. 20ms")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s serve [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	_ = flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	latency, err := parseLatency(latencySpec)
+	exitOnError(err)
+	srv.latency = latency
+
+	switch {
+	case srv.stallEvery < 0 || srv.stallFor < 0:
+		err = errors.New("stall durations must not be negative")
+	case srv.stallFor > 0 && srv.stallFor >= srv.stallEvery:
+		err = errors.New("-stall-for must be less than -stall-every")
+	case errorRate < 0 || errorRate > 100:
+		err = errors.New("-error-rate must be between 0 and 100")
+	case srv.errorStatus < 100 || srv.errorStatus > 599:
+		err = fmt.Errorf("-error-status %d is not a valid HTTP status code", srv.errorStatus)
+	case size < 0:
+		err = errors.New("-size must not be negative")
+	case srv.events < 0 || srv.eventGap < 0:
+		err = errors.New("-events and -event-gap must not be negative")
+	}
+	exitOnError(err)
+
+	srv.errorRatio = errorRate / 100
+	srv.body = make([]byte, size)
+	for i := range srv.body {
+		srv.body[i] = 'a' + byte(i%26)
+	}
+	srv.start = time.Now()
+
+	fmt.Printf("Serving HTTP/1.1 and h2c on %s with latency %s\n", listen, latencySpec)
+	// #nosec
+	exitOnError(http.ListenAndServe(listen, h2c.NewHandler(srv, &http2.Server{})))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLatency(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    latencyDistribution
+		wantErr string
+	}{
+		{"fixed:5ms", fixedLatency(5 * time.Millisecond), ""},
+		{"fixed:0s", fixedLatency(0), ""},
+		{"uniform:1ms, 10ms", uniformLatency{time.Millisecond, 10 * time.Millisecond}, ""},
+		{"uniform:5ms,5ms", uniformLatency{5 * time.Millisecond, 5 * time.Millisecond}, ""},
+		{"lognormal:20ms,0.5", lognormalLatency{20 * time.Millisecond, 0.5}, ""},
+		{"bimodal:2ms,200ms,1", bimodalLatency{2 * time.Millisecond, 200 * time.Millisecond, 0.01}, ""},
+		{"bimodal:2ms,200ms,100", bimodalLatency{2 * time.Millisecond, 200 * time.Millisecond, 1}, ""},
+
+		{"fixed:5", nil, "missing unit in duration"},
+		{"fixed:5parsecs", nil, "unknown unit"},
+		{"fixed:-1ms", nil, "latency must not be negative"},
+		{"fixed", nil, "invalid duration"},
+		{"uniform:10ms,1ms", nil, "max must not be less than min"},
+		{"uniform:1ms", nil, "expected fixed:<latency>"},
+		{"uniform:1ms,x", nil, "invalid duration"},
+		{"lognormal:20ms", nil, "expected fixed:<latency>"},
+		{"lognormal:20ms,x", nil, "invalid syntax"},
+		{"lognormal:20ms,-1", nil, "sigma must not be negative"},
+		{"bimodal:2ms,200ms", nil, "expected fixed:<latency>"},
+		{"bimodal:2ms,200ms,101", nil, "slow percentage must be between 0 and 100"},
+		{"bimodal:2ms,200ms,-1", nil, "slow percentage must be between 0 and 100"},
+		{"normal:5ms,1ms", nil, "expected fixed:<latency>"},
+		{"", nil, "expected fixed:<latency>"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseLatency(tt.spec)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.HasPrefix(err.Error(), tt.spec+":") {
+				t.Errorf("parseLatency(%q) error = %v, want %q", tt.spec, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLatency(%q) = %#v, %v, want %#v", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestLatencySample(t *testing.T) {
+	uniform := uniformLatency{time.Millisecond, 3 * time.Millisecond}
+	bimodal := bimodalLatency{time.Millisecond, time.Second, 0.5}
+	var slow int
+	for i := 0; i < 1000; i++ {
+		if d := uniform.sample(); d < uniform.min || d > uniform.max {
+			t.Fatalf("uniform sample %v outside of %v..%v", d, uniform.min, uniform.max)
+		}
+		switch bimodal.sample() {
+		case time.Second:
+			slow++
+		case time.Millisecond:
+		default:
+			t.Fatal("bimodal sample is neither fast nor slow")
+		}
+	}
+	if slow < 400 || slow > 600 {
+		t.Errorf("%d of 1000 bimodal samples slow, want about half", slow)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"time"
+
+	"labench/bench"
+)
+
+const (
+	// maxSocketResponse is the size limit of TCP responses.
+	maxSocketResponse = 1 << 20
+
+	// maxDatagram is the size limit of UDP responses, the largest UDP
+	// payload.
+	maxDatagram = 64 << 10
+
+	// socketConnectTiming is the name of the TCP connect latency histogram.
+	socketConnectTiming = "TCP connect"
+)
+
+func init() {
+	registerProtocol("TCP", func() requesterConfig { return &SocketRequesterFactory{network: "tcp"} })
+	registerProtocol("UDP", func() requesterConfig { return &SocketRequesterFactory{network: "udp"} })
+}
+
+// SocketRequesterFactory implements RequesterFactory by creating a Requester
+// which sends a raw payload over TCP or UDP and reads the response.
+type SocketRequesterFactory struct {
+	Address string `yaml:"Address"`
+	Payload string `yaml:"Payload"`
+
+	// Encoding of Payload and Response.Delimiter: text (the default), hex
+	// or base64.
+	Encoding string `yaml:"Encoding"`
+
+	// Response describes how to read the response, without it nothing is
+	// read.
+	Response *SocketResponse `yaml:"Response"`
+
+	// PersistentConnection sends all requests of a client over the same
+	// connection instead of opening one per request.
+	PersistentConnection bool `yaml:"PersistentConnection"`
+
+	network        string // tcp or udp
+	payload        []byte
+	delimiter      []byte
+	match          *regexp.Regexp
+	requestTimeout time.Duration
+}
+
+// SocketResponse describes the framing of TCP responses, exactly one of
+// Delimiter, Length and LengthPrefix must be set. A UDP response is a single
+// datagram. Match optionally validates the response.
+type SocketResponse struct {
+	Delimiter string `yaml:"Delimiter"`
+	Length    int    `yaml:"Length"`
+
+	// LengthPrefix is the size, in bytes, of the big-endian length which
+	// precedes the response.
+	LengthPrefix int `yaml:"LengthPrefix"`
+
+	// Match is a regular expression the response, without delimiter or
+	// length prefix, must match.
+	Match string `yaml:"Match"`
+}
+
+// decodePayload decodes text in one of the supported encodings.
+func decodePayload(text, encoding string) ([]byte, error) {
+	switch encoding {
+	case "", "text":
+		return []byte(text), nil
+	case "hex":
+		return hex.DecodeString(text)
+	case "base64":
+		return base64.StdEncoding.DecodeString(text)
+	default:
+		return nil, fmt.Errorf("unknown encoding %q, supported encodings are text, hex and base64", encoding)
+	}
+}
+
+// newFactory decodes the payload and compiles the response pattern.
+func (s *SocketRequesterFactory) newFactory(params *benchParams) (bench.RequesterFactory, error) {
+	s.requestTimeout = params.RequestTimeout
+
+	var err error
+	if s.payload, err = decodePayload(s.Payload, s.Encoding); err != nil {
+		return nil, err
+	}
+	if s.Response != nil {
+		if s.delimiter, err = decodePayload(s.Response.Delimiter, s.Encoding); err != nil {
+			return nil, err
+		}
+		if s.Response.Match != "" {
+			if s.match, err = regexp.Compile(s.Response.Match); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+func (s *SocketRequesterFactory) validate(errs *configErrors, path string) {
+	if _, _, err := net.SplitHostPort(s.Address); err != nil {
+		errs.add(path+".Address", "%v, expected host:port", err)
+	}
+	validEncoding := true
+	switch s.Encoding {
+	case "", "text", "hex", "base64":
+		if _, err := decodePayload(s.Payload, s.Encoding); err != nil {
+			errs.add(path+".Payload", "%v", err)
+		}
+	default:
+		validEncoding = false
+		errs.add(path+".Encoding", "unknown encoding %q, supported encodings are text, hex and base64", s.Encoding)
+	}
+
+	r := s.Response
+	if r == nil {
+		return
+	}
+	framings := 0
+	if r.Delimiter != "" {
+		framings++
+		if _, err := decodePayload(r.Delimiter, s.Encoding); err != nil && validEncoding {
+			errs.add(path+".Response.Delimiter", "%v", err)
+		}
+	}
+	if r.Length != 0 {
+		framings++
+		if r.Length < 0 || r.Length > s.maxResponse() {
+			errs.add(path+".Response.Length", "must be between 1 and %d", s.maxResponse())
+		}
+	}
+	if r.LengthPrefix != 0 {
+		framings++
+		if r.LengthPrefix != 1 && r.LengthPrefix != 2 && r.LengthPrefix != 4 && r.LengthPrefix != 8 {
+			errs.add(path+".Response.LengthPrefix", "must be 1, 2, 4 or 8 bytes")
+		}
+	}
+	switch {
+	case s.network == "udp" && framings > 0:
+		errs.add(path+".Response", "a UDP response is a single datagram, Delimiter, Length and LengthPrefix don't apply")
+	case s.network == "tcp" && framings != 1:
+		errs.add(path+".Response", "exactly one of Delimiter, Length and LengthPrefix must be set")
+	}
+	if r.Match != "" {
+		if _, err := regexp.Compile(r.Match); err != nil {
+			errs.add(path+".Response.Match", "%v", err)
+		}
+	}
+}
+
+// maxResponse returns the size limit of responses over the network.
+func (s *SocketRequesterFactory) maxResponse() int {
+	if s.network == "udp" {
+		return maxDatagram
+	}
+	return maxSocketResponse
+}
+
+// GetRequester returns a new Requester, called for each Benchmark connection.
+func (s *SocketRequesterFactory) GetRequester(uint64) bench.Requester {
+	return &socketRequester{factory: s}
+}
+
+// socketRequester implements Requester by sending the payload over a TCP or
+// UDP socket and reading the response.
+type socketRequester struct {
+	factory     *SocketRequesterFactory
+	conn        net.Conn
+	reader      *bufio.Reader
+	buf         []byte
+	timings     []bench.Timing
+	lastDetails bench.RequestDetails
+}
+
+// Setup opens the connection in persistent connection mode.
+func (s *socketRequester) Setup() error {
+	if s.factory.PersistentConnection {
+		return s.connect()
+	}
+	return nil
+}
+
+func (s *socketRequester) connect() error {
+	before := time.Now()
+	conn, err := net.DialTimeout(s.factory.network, s.factory.Address, s.factory.requestTimeout)
+	if err != nil {
+		return err
+	}
+	// UDP sockets aren't connected to anything
+	if s.factory.network == "tcp" {
+		s.timings = append(s.timings, bench.Timing{Name: socketConnectTiming, Duration: time.Since(before)})
+	}
+	s.conn = conn
+	s.reader = bufio.NewReader(conn)
+	return nil
+}
+
+// Request sends the payload and reads the response. A broken persistent
+// connection is reopened by the next Request.
+func (s *socketRequester) Request() error {
+	s.lastDetails = bench.RequestDetails{Target: s.factory.Address, BytesSent: int64(len(s.factory.payload))}
+
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	if !s.factory.PersistentConnection {
+		defer s.Teardown()
+	}
+
+	if s.factory.requestTimeout > 0 {
+		_ = s.conn.SetDeadline(time.Now().Add(s.factory.requestTimeout))
+	}
+	if _, err := s.conn.Write(s.factory.payload); err != nil {
+		_ = s.Teardown()
+		return err
+	}
+
+	if s.factory.Response == nil {
+		return nil
+	}
+	resp, err := s.readResponse()
+	if err != nil {
+		_ = s.Teardown()
+		return err
+	}
+	s.lastDetails.BytesReceived = int64(len(resp))
+
+	if s.factory.match != nil && !s.factory.match.Match(resp) {
+		return fmt.Errorf("Response doesn't match %s", s.factory.Response.Match)
+	}
+	return nil
+}
+
+// readResponse reads the next response according to the framing.
+func (s *socketRequester) readResponse() ([]byte, error) {
+	r := s.factory.Response
+	switch {
+	case s.factory.network == "udp":
+		if s.buf == nil {
+			s.buf = make([]byte, maxDatagram)
+		}
+		n, err := s.conn.Read(s.buf)
+		return s.buf[:n], err
+
+	case len(s.factory.delimiter) > 0:
+		s.buf = s.buf[:0]
+		for !bytes.HasSuffix(s.buf, s.factory.delimiter) {
+			b, err := s.reader.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if len(s.buf) == maxSocketResponse {
+				return nil, errors.New("response delimiter not found")
+			}
+			s.buf = append(s.buf, b)
+		}
+		return s.buf[:len(s.buf)-len(s.factory.delimiter)], nil
+
+	case r.LengthPrefix > 0:
+		var prefix [8]byte
+		if _, err := io.ReadFull(s.reader, prefix[:r.LengthPrefix]); err != nil {
+			return nil, err
+		}
+		length := uint64(0)
+		for _, b := range prefix[:r.LengthPrefix] {
+			length = length<<8 | uint64(b)
+		}
+		if length > maxSocketResponse {
+			return nil, fmt.Errorf("response length %d exceeds the limit of %d bytes", length, maxSocketResponse)
+		}
+		return s.readFull(int(length))
+
+	default:
+		return s.readFull(r.Length)
+	}
+}
+
+func (s *socketRequester) readFull(n int) ([]byte, error) {
+	if cap(s.buf) < n {
+		s.buf = make([]byte, n)
+	}
+	s.buf = s.buf[:n]
+	_, err := io.ReadFull(s.reader, s.buf)
+	return s.buf, err
+}
+
+// Teardown closes the connection.
+func (s *socketRequester) Teardown() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.reader = nil, nil
+	return err
+}
+
+// TakeTimings returns the TCP connect latencies since the last call.
+func (s *socketRequester) TakeTimings() []bench.Timing {
+	timings := s.timings
+	s.timings = nil
+	return timings
+}
+
+// LastRequestDetails returns the details of the last Request call.
+func (s *socketRequester) LastRequestDetails() bench.RequestDetails { return s.lastDetails }
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodePayload(t *testing.T) {
+	tests := []struct {
+		text, encoding string
+		want           []byte
+		wantErr        bool
+	}{
+		{"PING\n", "", []byte("PING\n"), false},
+		{"PING\n", "text", []byte("PING\n"), false},
+		{"0d0a", "hex", []byte("\r\n"), false},
+		{"0d0", "hex", nil, true},
+		{"UElORw==", "base64", []byte("PING"), false},
+		{"UElORw", "base64", nil, true},
+		{"PING", "rot13", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := decodePayload(tt.text, tt.encoding)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && !bytes.Equal(got, tt.want)) {
+			t.Errorf("decodePayload(%q, %q) = %q, %v, want %q, error %v", tt.text, tt.encoding, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSocketReadResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		response SocketResponse
+		input    string
+		want     []string
+		wantErr  string
+	}{
+		{
+			name:     "delimiter",
+			response: SocketResponse{Delimiter: "\r\n"},
+			input:    "PONG\r\nPONG 2\r\n\r\n",
+			want:     []string{"PONG", "PONG 2", ""},
+		},
+		{
+			name:     "hex delimiter",
+			encoding: "hex",
+			response: SocketResponse{Delimiter: "00"},
+			input:    "a\x00bc\x00",
+			want:     []string{"a", "bc"},
+		},
+		{
+			name:     "missing delimiter",
+			response: SocketResponse{Delimiter: "\n"},
+			input:    "PONG",
+			wantErr:  "EOF",
+		},
+		{
+			name:     "length",
+			response: SocketResponse{Length: 3},
+			input:    "abcdef",
+			want:     []string{"abc", "def"},
+		},
+		{
+			name:     "short length",
+			response: SocketResponse{Length: 3},
+			input:    "ab",
+			wantErr:  "unexpected EOF",
+		},
+		{
+			name:     "length prefix 1",
+			response: SocketResponse{LengthPrefix: 1},
+			input:    "\x02ab\x00\x01c",
+			want:     []string{"ab", "", "c"},
+		},
+		{
+			name:     "length prefix 2",
+			response: SocketResponse{LengthPrefix: 2},
+			input:    "\x00\x03abc",
+			want:     []string{"abc"},
+		},
+		{
+			name:     "length prefix too long",
+			response: SocketResponse{LengthPrefix: 8},
+			input:    "\x00\x00\x00\x01\x00\x00\x00\x00",
+			wantErr:  "exceeds the limit",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &SocketRequesterFactory{network: "tcp", Encoding: tt.encoding, Response: &tt.response}
+			factory, err := config.newFactory(&benchParams{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := factory.GetRequester(0).(*socketRequester)
+			s.reader = bufio.NewReader(strings.NewReader(tt.input))
+
+			var got []string
+			for {
+				resp, err := s.readResponse()
+				if err == io.EOF && tt.wantErr == "" {
+					break
+				}
+				if err != nil {
+					if tt.wantErr == "" || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("readResponse() error = %v, want %q", err, tt.wantErr)
+					}
+					return
+				}
+				got = append(got, string(resp))
+			}
+			if tt.wantErr != "" {
+				t.Fatalf("readResponse() error = nil, want %q", tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("responses = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSocketRequester(t *testing.T) {
+	// Replies PONG to every line, and BAD to the third one of a connection
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for i := 1; ; i++ {
+					if _, err := r.ReadString('\n'); err != nil {
+						return
+					}
+					reply := "PONG\n"
+					if i == 3 {
+						reply = "BAD\n"
+					}
+					if _, err := conn.Write([]byte(reply)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	for _, persistent := range []bool{true, false} {
+		config := &SocketRequesterFactory{
+			network:              "tcp",
+			Address:              ln.Addr().String(),
+			Payload:              "PING\n",
+			PersistentConnection: persistent,
+			Response:             &SocketResponse{Delimiter: "\n", Match: "^PONG$"},
+		}
+		factory, err := config.newFactory(&benchParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := factory.GetRequester(0).(*socketRequester)
+		if err := s.Setup(); err != nil {
+			t.Fatalf("Setup() error = %v", err)
+		}
+
+		var failures int
+		for i := 0; i < 4; i++ {
+			if err := s.Request(); err != nil {
+				failures++
+			}
+			if d := s.LastRequestDetails(); d.BytesSent != 5 || d.BytesReceived < 3 {
+				t.Errorf("persistent %v: bytes sent, received = %d, %d", persistent, d.BytesSent, d.BytesReceived)
+			}
+		}
+		_ = s.Teardown()
+
+		// Only the third request on a connection gets a bad reply
+		wantFailures, wantConnects := 1, 1
+		if !persistent {
+			wantFailures, wantConnects = 0, 4
+		}
+		if failures != wantFailures {
+			t.Errorf("persistent %v: %d failures, want %d", persistent, failures, wantFailures)
+		}
+		if n := len(s.TakeTimings()); n != wantConnects {
+			t.Errorf("persistent %v: %d connect timings, want %d", persistent, n, wantConnects)
+		}
+	}
+}
+
+func TestSocketValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SocketRequesterFactory
+		wantErr string
+	}{
+		{"valid", SocketRequesterFactory{network: "tcp", Address: "localhost:7", Response: &SocketResponse{Length: 4}}, ""},
+		{"valid UDP", SocketRequesterFactory{network: "udp", Address: "localhost:7", Response: &SocketResponse{Match: "^PONG"}}, ""},
+		{"address", SocketRequesterFactory{network: "tcp", Address: "localhost"}, "Request.Address: "},
+		{"encoding", SocketRequesterFactory{network: "tcp", Address: "localhost:7", Encoding: "rot13"}, `Request.Encoding: unknown encoding "rot13"`},
+		{"payload", SocketRequesterFactory{network: "tcp", Address: "localhost:7", Encoding: "hex", Payload: "xyz"}, "Request.Payload: "},
+		{"no framing", SocketRequesterFactory{network: "tcp", Address: "localhost:7", Response: &SocketResponse{}}, "exactly one of Delimiter, Length and LengthPrefix"},
+		{"two framings", SocketRequesterFactory{network: "tcp", Address: "localhost:7", Response: &SocketResponse{Length: 4, Delimiter: "\n"}}, "exactly one of Delimiter, Length and LengthPrefix"},
+		{"UDP framing", SocketRequesterFactory{network: "udp", Address: "localhost:7", Response: &SocketResponse{Length: 4}}, "a UDP response is a single datagram"},
+		{"length", SocketRequesterFactory{network: "tcp", Address: "localhost:7", Response: &SocketResponse{Length: maxSocketResponse + 1}}, "Request.Response.Length: must be between 1 and 1048576"},
+		{"UDP length", SocketRequesterFactory{network: "udp", Address: "localhost:7", Response: &SocketResponse{Length: maxSocketResponse}}, "Request.Response.Length: must be between 1 and 65536"},
+		{"length prefix", SocketRequesterFactory{network: "tcp", Address: "localhost:7", Response: &SocketResponse{LengthPrefix: 3}}, "must be 1, 2, 4 or 8 bytes"},
+		{"match", SocketRequesterFactory{network: "tcp", Address: "localhost:7", Response: &SocketResponse{Length: 4, Match: "("}}, "Request.Response.Match: "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs configErrors
+			tt.config.validate(&errs, "Request")
+			switch {
+			case tt.wantErr == "" && len(errs) > 0:
+				t.Errorf("validate() errors = %v", errs)
+			case tt.wantErr != "" && !strings.Contains(errs.Error(), tt.wantErr):
+				t.Errorf("validate() errors = %v, want %q", errs, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"time"
+
+	"labench/bench"
+)
+
+// sweepParams describes a throughput-latency sweep: the benchmark is run
+// once for every request rate, either listed explicitly in Rates or
+// following a geometric series from StartRate.
+type sweepParams struct {
+	Rates     []uint64      `yaml:"Rates"`
+	StartRate uint64        `yaml:"StartRate"`
+	Factor    float64       `yaml:"Factor"`
+	Steps     int           `yaml:"Steps"`
+	MaxRate   uint64        `yaml:"MaxRate"`
+	CoolDown  time.Duration `yaml:"CoolDown"`
+}
+
+// maxSweepSteps bounds a geometric series which is only limited by MaxRate.
+const maxSweepSteps = 100
+
+// rates returns the request rates to run the benchmark at, in order. Steps of
+// the series which round to the rate of the previous step are skipped, every
+// rate writes to its own output directory.
+func (s *sweepParams) rates() []uint64 {
+	if len(s.Rates) > 0 {
+		return s.Rates
+	}
+
+	steps := s.Steps
+	if steps == 0 {
+		steps = maxSweepSteps
+	}
+
+	var rates []uint64
+	for i := 0; i < steps; i++ {
+		rate := uint64(math.Round(float64(s.StartRate) * math.Pow(s.Factor, float64(i))))
+		if s.MaxRate > 0 && rate > s.MaxRate {
+			break
+		}
+		if len(rates) > 0 && rate == rates[len(rates)-1] {
+			continue
+		}
+		rates = append(rates, rate)
+	}
+	return rates
+}
+
+func (s *sweepParams) validate(errs *configErrors, path string) {
+	if len(s.Rates) > 0 {
+		if s.StartRate != 0 || s.Factor != 0 || s.Steps != 0 || s.MaxRate != 0 {
+			errs.add(path+".Rates", "is mutually exclusive with StartRate, Factor, Steps and MaxRate")
+		}
+		seen := make(map[uint64]bool, len(s.Rates))
+		for i, rate := range s.Rates {
+			switch {
+			case rate == 0:
+				errs.add(fmt.Sprintf("%s.Rates[%d]", path, i), "must be positive")
+			case seen[rate]:
+				errs.add(fmt.Sprintf("%s.Rates[%d]", path, i), "%d is listed twice", rate)
+			}
+			seen[rate] = true
+		}
+	} else {
+		if s.StartRate == 0 {
+			errs.add(path, "either Rates or StartRate must be set")
+		}
+		if s.Factor <= 1 {
+			errs.add(path+".Factor", "must be greater than 1")
+		}
+		if s.Steps < 0 {
+			errs.add(path+".Steps", "must not be negative")
+		}
+		if s.Steps == 0 && s.MaxRate == 0 {
+			errs.add(path, "either Steps or MaxRate must be set")
+		}
+		if s.MaxRate > 0 && s.MaxRate < s.StartRate {
+			errs.add(path+".MaxRate", "must not be less than StartRate")
+		}
+	}
+
+	if s.CoolDown < 0 {
+		errs.add(path+".CoolDown", "must not be negative")
+	}
+}
+
+// runSweep runs the benchmark at every rate of the sweep, writing the results
+// of each rate to its own directory and a combined table and report to outDir.
+func runSweep(run benchmarkRun, outDir string) (bench.SummaryTable, error) {
+	var (
+		sweep   = run.Config.Sweep
+		rates   = sweep.rates()
+		results bench.SummaryTable
+	)
+
+	for i, rate := range rates {
+		if i > 0 && sweep.CoolDown > 0 {
+			fmt.Printf("\nCooling down for %v\n", sweep.CoolDown)
+			time.Sleep(sweep.CoolDown)
+		}
+
+		name := "rate-" + strconv.FormatUint(rate, 10)
+		fmt.Printf("\n=== Sweep %d/%d: %d req/s\n", i+1, len(rates), rate)
+
+		// Clients are sized for each rate, unless they are set explicitly
+		conf, configBytes, errs := decodeConfig(run.ConfigBytes, []configOverride{{"Sweep", nil}, {"RequestRatePerSec", rate}})
+		if len(errs) > 0 {
+			return nil, errs
+		}
+
+		summary, err := runBenchmark(conf, configBytes, path.Join(outDir, name), time.Time{})
+		if err != nil {
+			return nil, err
+		}
+
+		if run.Name != "" {
+			name = run.Name + "/" + name
+		}
+		results = append(results, bench.NamedSummary{Name: name, Summary: summary})
+	}
+
+	fmt.Println("\nSweep results:")
+	fmt.Println(results)
+
+	if err := results.WriteCSV(path.Join(outDir, "sweep.csv")); err != nil {
+		return nil, err
+	}
+	if err := results.GenerateSweepReport(string(run.ConfigBytes), path.Join(outDir, "sweep.html")); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSweepRates(t *testing.T) {
+	tests := []struct {
+		name  string
+		sweep sweepParams
+		want  []uint64
+	}{
+		{"listed", sweepParams{Rates: []uint64{300, 100}}, []uint64{300, 100}},
+		{"steps", sweepParams{StartRate: 100, Factor: 2, Steps: 4}, []uint64{100, 200, 400, 800}},
+		{"max rate", sweepParams{StartRate: 100, Factor: 2, MaxRate: 1000}, []uint64{100, 200, 400, 800}},
+		{"steps and max rate", sweepParams{StartRate: 100, Factor: 2, Steps: 10, MaxRate: 400}, []uint64{100, 200, 400}},
+		{"rounded", sweepParams{StartRate: 10, Factor: 1.5, Steps: 4}, []uint64{10, 15, 23, 34}},
+		{"duplicates", sweepParams{StartRate: 1, Factor: 1.2, Steps: 6}, []uint64{1, 2}},
+		{"duplicates up to max rate", sweepParams{StartRate: 2, Factor: 1.1, MaxRate: 3}, []uint64{2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sweep.rates(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSweepValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		sweep sweepParams
+		want  []string
+	}{
+		{"valid rates", sweepParams{Rates: []uint64{100, 200}}, nil},
+		{"valid series", sweepParams{StartRate: 100, Factor: 2, Steps: 3}, nil},
+		{"duplicate rates", sweepParams{Rates: []uint64{100, 200, 100}}, []string{"Sweep.Rates[2]: 100 is listed twice"}},
+		{"zero rate", sweepParams{Rates: []uint64{0}}, []string{"Sweep.Rates[0]: must be positive"}},
+		{"factor", sweepParams{StartRate: 100, Factor: 1, Steps: 3}, []string{"Sweep.Factor: must be greater than 1"}},
+		{"unbounded", sweepParams{StartRate: 100, Factor: 2}, []string{"Sweep: either Steps or MaxRate must be set"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs configErrors
+			tt.sweep.validate(&errs, "Sweep")
+			var got []string
+			for _, e := range errs {
+				got = append(got, e.Path+": "+e.Message)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
@@ -9,8 +11,10 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync/atomic"
 
@@ -84,6 +88,11 @@ func initHTTP2Client(requestTimeout time.Duration, dontLinger bool) {
 	noLinger = dontLinger
 }
 
+func init() {
+	registerProtocol("HTTP/1.1", func() requesterConfig { return &WebRequesterFactory{} })
+	registerProtocol("HTTP/2", func() requesterConfig { return &WebRequesterFactory{http2: true} })
+}
+
 // WebRequesterFactory implements RequesterFactory by creating a Requester
 // which makes GET requests to the provided URL.
 type WebRequesterFactory struct {
@@ -95,7 +104,107 @@ type WebRequesterFactory struct {
 	ExpectedHTTPStatusCode int               `yaml:"ExpectedHTTPStatusCode"`
 	HTTPMethod             string            `yaml:"HTTPMethod"`
 
+	// URLsOnce requests every one of URLs once, in order, and then stops the
+	// benchmark instead of cycling through them.
+	URLsOnce bool `yaml:"URLsOnce"`
+
+	// Streaming reads the response as a stream of events, Server-Sent Events
+	// or, for other content types, lines, and times each of them.
+	Streaming bool `yaml:"Streaming"`
+
+	// ServerTimeHeader is the response header holding the processing time
+	// reported by the server, defaults to Server-Timing.
+	ServerTimeHeader string `yaml:"ServerTimeHeader"`
+
+	// TagHeaders are response headers whose values tag the requests, e.g.
+	// X-Cache, the requests are counted by tag value.
+	TagHeaders []string `yaml:"TagHeaders"`
+
+	http2           bool
 	expandedHeaders map[string][]string
+	nextHostOrURL   int32
+}
+
+// newFactory sets up the HTTP client and fills in the default status code and
+// method.
+func (w *WebRequesterFactory) newFactory(params *benchParams) (bench.RequesterFactory, error) {
+	if w.http2 {
+		initHTTP2Client(params.RequestTimeout, params.DontLinger)
+	} else {
+		initHTTPClient(params.ReuseConnections, params.RequestTimeout, params.DontLinger)
+	}
+
+	if w.ExpectedHTTPStatusCode == 0 {
+		w.ExpectedHTTPStatusCode = http.StatusOK
+	}
+	if w.HTTPMethod == "" {
+		if w.Body == "" {
+			w.HTTPMethod = http.MethodGet
+		} else {
+			w.HTTPMethod = http.MethodPost
+		}
+	}
+	if w.ServerTimeHeader == "" {
+		w.ServerTimeHeader = "Server-Timing"
+	}
+	return w, nil
+}
+
+// exhaustible reports whether the benchmark stops after requesting all URLs.
+func (w *WebRequesterFactory) exhaustible() bool { return w.URLsOnce }
+
+func (w *WebRequesterFactory) validate(errs *configErrors, path string) {
+	switch {
+	case w.URL == "" && len(w.URLs) == 0:
+		errs.add(path, "either URL or URLs must be set")
+	case w.URL != "" && len(w.URLs) > 0:
+		errs.add(path+".URLs", "is mutually exclusive with URL")
+	}
+
+	if w.URLsOnce && len(w.URLs) == 0 {
+		errs.add(path+".URLsOnce", "requires URLs")
+	}
+
+	if len(w.Hosts) > 0 {
+		switch {
+		case len(w.URLs) > 0:
+			errs.add(path+".Hosts", "can't be used with URLs, Hosts are substituted into URL")
+		case w.URL == "":
+			errs.add(path+".Hosts", "requires URL, Hosts are substituted into it")
+		}
+	}
+
+	if w.URL != "" {
+		validateURL(errs, path+".URL", w.URL)
+	}
+	for i, u := range w.URLs {
+		validateURL(errs, fmt.Sprintf("%s.URLs[%d]", path, i), u)
+	}
+	for i, h := range w.Hosts {
+		if h == "" || strings.ContainsAny(h, "/?#") {
+			errs.add(fmt.Sprintf("%s.Hosts[%d]", path, i), "%q is not a valid host[:port]", h)
+		}
+	}
+
+	if w.ExpectedHTTPStatusCode != 0 && (w.ExpectedHTTPStatusCode < 100 || w.ExpectedHTTPStatusCode > 599) {
+		errs.add(path+".ExpectedHTTPStatusCode", "%d is not a valid HTTP status code", w.ExpectedHTTPStatusCode)
+	}
+
+	if w.HTTPMethod != "" && strings.ToUpper(w.HTTPMethod) != w.HTTPMethod {
+		errs.add(path+".HTTPMethod", "methods are case sensitive, did you mean %s?", strings.ToUpper(w.HTTPMethod))
+	}
+}
+
+func validateURL(errs *configErrors, path, rawURL string) {
+	u, err := url.Parse(rawURL)
+	switch {
+	case err != nil:
+		errs.add(path, "%v", err)
+	case u.Scheme != "http" && u.Scheme != "https":
+		errs.add(path, "%q must start with http:// or https://", rawURL)
+	case u.Host == "":
+		errs.add(path, "%q has no host", rawURL)
+	}
 }
 
 // GetRequester returns a new Requester, called for each Benchmark connection.
@@ -109,7 +218,7 @@ func (w *WebRequesterFactory) GetRequester(uint64) bench.Requester {
 		w.expandedHeaders = expandedHeaders
 	}
 
-	return &webRequester{w.URL, w.URLs, w.Hosts, w.expandedHeaders, w.Body, w.ExpectedHTTPStatusCode, w.HTTPMethod}
+	return &webRequester{url: w.URL, urls: w.URLs, hosts: w.Hosts, headers: w.expandedHeaders, body: w.Body, expectedReturnCode: w.ExpectedHTTPStatusCode, httpMethod: w.HTTPMethod, urlsOnce: w.URLsOnce, streaming: w.Streaming, serverTimeHeader: w.ServerTimeHeader, tagHeaders: w.TagHeaders, nextHostOrURL: &w.nextHostOrURL}
 }
 
 // webRequester implements Requester by making a GET request to the provided
@@ -122,10 +231,16 @@ type webRequester struct {
 	body               string
 	expectedReturnCode int
 	httpMethod         string
+	urlsOnce           bool
+	streaming          bool
+	serverTimeHeader   string
+	tagHeaders         []string
+	nextHostOrURL      *int32 // shared by the requesters of a factory
+	lastDetails        bench.RequestDetails
+	timings            []bench.Timing
+	counts             []bench.Count
 }
 
-var nextHostOrURL int32 = -1
-
 // Setup prepares the Requester for benchmarking.
 func (w *webRequester) Setup() error { return nil }
 
@@ -133,26 +248,38 @@ func (w *webRequester) Setup() error { return nil }
 func (w *webRequester) Request() error {
 	var reqURL string
 	if w.urls != nil {
-		h := atomic.AddInt32(&nextHostOrURL, 1)
+		h := atomic.AddInt32(w.nextHostOrURL, 1) - 1
+		if w.urlsOnce && h >= int32(len(w.urls)) {
+			return bench.ErrSourceExhausted
+		}
 		reqURL = w.urls[h%int32(len(w.urls))]
 	} else if w.hosts != nil {
 		parsedURL, err := url.Parse(w.url)
 		if err != nil {
 			return err
 		}
-		h := atomic.AddInt32(&nextHostOrURL, 1)
+		h := atomic.AddInt32(w.nextHostOrURL, 1) - 1
 		parsedURL.Host = w.hosts[h%int32(len(w.hosts))]
 		reqURL = parsedURL.String()
 	} else {
 		reqURL = w.url
 	}
 
+	w.lastDetails = bench.RequestDetails{Target: reqURL, BytesSent: int64(len(w.body))}
+
 	req, err := http.NewRequest(w.httpMethod, reqURL, strings.NewReader(w.body))
 	if err != nil {
 		return err
 	}
 
 	req.Header = w.headers
+
+	var start, firstByte time.Time
+	if w.streaming {
+		trace := &httptrace.ClientTrace{GotFirstResponseByte: func() { firstByte = time.Now() }}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		start = time.Now()
+	}
 	resp, err := httpClient.Do(req)
 
 	/* to look at the response body
@@ -162,9 +289,19 @@ func (w *webRequester) Request() error {
 	_ = s
 	*/
 
+	var streamErr error
 	// #nosec
 	if resp != nil && resp.Body != nil {
-		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		// Error responses are not timed
+		if w.streaming && err == nil && resp.StatusCode == w.expectedReturnCode {
+			if !firstByte.IsZero() {
+				w.timings = append(w.timings, bench.Timing{Name: "Time to first byte", Duration: firstByte.Sub(start)})
+			}
+			sse := strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+			streamErr = w.readStream(resp.Body, sse, start)
+		} else {
+			w.lastDetails.BytesReceived, _ = io.Copy(ioutil.Discard, resp.Body)
+		}
 		_ = resp.Body.Close()
 	}
 
@@ -176,12 +313,131 @@ func (w *webRequester) Request() error {
 		return errors.New("Nil response")
 	}
 
+	w.lastDetails.StatusCode = resp.StatusCode
+	w.lastDetails.HasStatus = true
+	w.lastDetails.ServerTime = parseServerTime(resp.Header.Get(w.serverTimeHeader))
+	for _, name := range w.tagHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			if w.lastDetails.Tags == nil {
+				w.lastDetails.Tags = make(map[string]string, len(w.tagHeaders))
+			}
+			w.lastDetails.Tags[name] = value
+		}
+	}
+
 	if resp.StatusCode != w.expectedReturnCode {
 		return fmt.Errorf("Expected %v got %v", w.expectedReturnCode, resp.StatusCode)
 	}
 
+	return streamErr
+}
+
+// parseServerTime parses the processing time reported by the server, either
+// in the Server-Timing format, where the longest of the durations is used as
+// it usually spans the others, or as a duration like 12ms or a number of
+// milliseconds. Anything else is reported as zero.
+func parseServerTime(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if d, err := time.ParseDuration(strings.TrimSpace(header)); err == nil {
+		return d
+	}
+	if ms, err := strconv.ParseFloat(strings.TrimSpace(header), 64); err == nil {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+
+	var longest time.Duration
+	for _, metric := range strings.Split(header, ",") {
+		for _, param := range strings.Split(metric, ";") {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "dur=") {
+				continue
+			}
+			ms, err := strconv.ParseFloat(strings.TrimPrefix(param, "dur="), 64)
+			if d := time.Duration(ms * float64(time.Millisecond)); err == nil && d > longest {
+				longest = d
+			}
+		}
+	}
+	return longest
+}
+
+// readStream reads a streamed response body and times its events: Server-Sent
+// Events, which end with an empty line, or non-empty lines.
+func (w *webRequester) readStream(body io.Reader, sse bool, start time.Time) error {
+	var (
+		reader    = bufio.NewReader(body)
+		events    int64
+		lastEvent time.Time
+		inEvent   bool
+	)
+	event := func() {
+		now := time.Now()
+		if events == 0 {
+			w.timings = append(w.timings, bench.Timing{Name: "Time to first event", Duration: now.Sub(start)})
+		} else {
+			w.timings = append(w.timings, bench.Timing{Name: "Inter-event gap", Duration: now.Sub(lastEvent)})
+		}
+		lastEvent = now
+		events++
+	}
+
+	for {
+		line, err := reader.ReadSlice('\n')
+		w.lastDetails.BytesReceived += int64(len(line))
+		// Long lines are read in parts, which make them part of an event
+		complete := err == nil || (err == io.EOF && len(line) > 0)
+		empty := len(bytes.TrimRight(line, "\r\n")) == 0
+
+		switch {
+		case err == bufio.ErrBufferFull:
+			inEvent = true
+		case !complete:
+		case sse && empty:
+			if inEvent {
+				event()
+			}
+			inEvent = false
+		case sse:
+			// Comments keep the connection alive, they are no events
+			inEvent = inEvent || line[0] != ':'
+		default:
+			if inEvent || !empty {
+				event()
+			}
+			inEvent = false
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+
+	w.timings = append(w.timings, bench.Timing{Name: "Stream duration", Duration: time.Since(start)})
+	w.counts = append(w.counts, bench.Count{Name: "Events per request", Value: events})
 	return nil
 }
 
 // Teardown is called upon benchmark completion.
 func (w *webRequester) Teardown() error { return nil }
+
+// LastRequestDetails returns the details of the last Request call.
+func (w *webRequester) LastRequestDetails() bench.RequestDetails { return w.lastDetails }
+
+// TakeTimings returns the timings of the last streamed response.
+func (w *webRequester) TakeTimings() []bench.Timing {
+	timings := w.timings
+	w.timings = nil
+	return timings
+}
+
+// TakeCounts returns the number of events of the last streamed response.
+func (w *webRequester) TakeCounts() []bench.Count {
+	counts := w.counts
+	w.counts = nil
+	return counts
+}
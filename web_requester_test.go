@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"labench/bench"
+)
+
+func TestReadStream(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	tests := []struct {
+		name       string
+		sse        bool
+		body       string
+		wantEvents int64
+	}{
+		{"SSE", true, "data: a\n\ndata: b\ndata: c\n\n", 2},
+		{"SSE CRLF", true, "data: a\r\n\r\nid: 2\r\ndata: b\r\n\r\n", 2},
+		{"SSE comments", true, ": keep-alive\n\ndata: a\n: inside\n\n", 1},
+		{"SSE blank lines", true, "\n\ndata: a\n\n\n\n", 1},
+		{"SSE unterminated", true, "data: a\n\ndata: b", 1},
+		{"SSE long line", true, "data: " + long + "\n\n", 1},
+		{"SSE empty", true, "", 0},
+		{"lines", false, "a\nb\n\nc", 3},
+		{"lines CRLF", false, "a\r\n\r\nb\r\n", 2},
+		{"long line", false, long + "\ny\n", 2},
+		{"lines empty", false, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &webRequester{}
+			if err := w.readStream(strings.NewReader(tt.body), tt.sse, time.Now()); err != nil {
+				t.Fatalf("readStream() error = %v", err)
+			}
+
+			counts := w.TakeCounts()
+			if len(counts) != 1 || counts[0].Name != "Events per request" || counts[0].Value != tt.wantEvents {
+				t.Fatalf("counts = %+v, want %d events", counts, tt.wantEvents)
+			}
+			if w.lastDetails.BytesReceived != int64(len(tt.body)) {
+				t.Errorf("BytesReceived = %d, want %d", w.lastDetails.BytesReceived, len(tt.body))
+			}
+
+			names := map[string]int64{}
+			for _, timing := range w.TakeTimings() {
+				names[timing.Name]++
+			}
+			var firstEvents, gaps int64
+			if tt.wantEvents > 0 {
+				firstEvents, gaps = 1, tt.wantEvents-1
+			}
+			if names["Time to first event"] != firstEvents || names["Inter-event gap"] != gaps || names["Stream duration"] != 1 {
+				t.Errorf("timings = %v, want %d first event, %d gaps and the stream duration", names, firstEvents, gaps)
+			}
+		})
+	}
+}
+
+func TestReadStreamError(t *testing.T) {
+	reset := errors.New("connection reset")
+	w := &webRequester{}
+	body := io.MultiReader(strings.NewReader("data: a\n\n"), iotest.ErrReader(reset))
+	if err := w.readStream(body, true, time.Now()); err != reset {
+		t.Errorf("readStream() error = %v, want %v", err, reset)
+	}
+}
+
+func TestParseServerTime(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"12ms", 12 * time.Millisecond},
+		{" 1.5s ", 1500 * time.Millisecond},
+		{"12", 12 * time.Millisecond},
+		{"0.25", 250 * time.Microsecond},
+		{"app;dur=12.5", 12500 * time.Microsecond},
+		{"db;dur=53, app;dur=47.2, total;desc=\"Total\";dur=120", 120 * time.Millisecond},
+		{"cache;desc=\"Cache Read\";dur=23.2", 23200 * time.Microsecond},
+		{"miss, db;dur=x", 0},
+		{"cache;desc=hit", 0},
+		{"not a time", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseServerTime(tt.header); got != tt.want {
+			t.Errorf("parseServerTime(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestWebRequesterDetails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Server-Timing", "app;dur=7")
+		w.Header().Set("X-Cache", "hit")
+		if string(body) == "fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		body    string
+		wantErr string
+		want    bench.RequestDetails
+	}{
+		{
+			body: "ok",
+			want: bench.RequestDetails{Target: server.URL, StatusCode: 200, HasStatus: true, BytesSent: 2, BytesReceived: 5,
+				ServerTime: 7 * time.Millisecond, Tags: map[string]string{"X-Cache": "hit"}},
+		},
+		{
+			body:    "fail",
+			wantErr: "Expected 200 got 503",
+			want: bench.RequestDetails{Target: server.URL, StatusCode: 503, HasStatus: true, BytesSent: 4, BytesReceived: 5,
+				ServerTime: 7 * time.Millisecond, Tags: map[string]string{"X-Cache": "hit"}},
+		},
+	}
+
+	for _, tt := range tests {
+		config := &WebRequesterFactory{URL: server.URL, Body: tt.body, TagHeaders: []string{"X-Cache", "X-Missing"}}
+		factory, err := config.newFactory(&benchParams{RequestTimeout: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := factory.GetRequester(0).(bench.DetailedRequester)
+		err = r.Request()
+		if (err == nil) != (tt.wantErr == "") || (err != nil && err.Error() != tt.wantErr) {
+			t.Errorf("%s: Request() error = %v, want %q", tt.body, err, tt.wantErr)
+		}
+		if got := r.LastRequestDetails(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: LastRequestDetails() = %+v, want %+v", tt.body, got, tt.want)
+		}
+	}
+
+	// Without a response there is no status
+	config := &WebRequesterFactory{URL: "http://127.0.0.1:1/"}
+	factory, err := config.newFactory(&benchParams{RequestTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := factory.GetRequester(0).(bench.DetailedRequester)
+	if err := r.Request(); err == nil {
+		t.Fatal("Request() without a server succeeded")
+	}
+	if d := r.LastRequestDetails(); d.HasStatus {
+		t.Errorf("LastRequestDetails() = %+v, want no status", d)
+	}
+}
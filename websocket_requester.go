@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"labench/bench"
+)
+
+func init() {
+	registerProtocol("WebSocket", func() requesterConfig { return &WebSocketRequesterFactory{} })
+}
+
+// webSocketHandshakeTiming is the name of the handshake latency histogram.
+const webSocketHandshakeTiming = "WebSocket handshake"
+
+// errWebSocketReopened fails a request which had to reopen the connection, so
+// the handshake isn't part of the latency of a successful request.
+var errWebSocketReopened = errors.New("WebSocket connection reopened, no message sent")
+
+// WebSocketRequesterFactory implements RequesterFactory by creating a
+// Requester which keeps a WebSocket connection open for the whole benchmark
+// and measures the latency from sending a message to receiving its reply.
+type WebSocketRequesterFactory struct {
+	URL     string            `yaml:"URL"`
+	Origin  string            `yaml:"Origin"`
+	Headers map[string]string `yaml:"Headers"`
+
+	// Message is a text/template of the message sent by every request, with
+	// the fields ID (unique per request), Client and Seq.
+	Message string `yaml:"Message"`
+
+	// CorrelationField is the dot separated path of the field in JSON
+	// replies which holds the ID or Seq of the message replied to, as a
+	// string or a number, other messages are skipped. Without it the next
+	// message received is the reply.
+	CorrelationField string `yaml:"CorrelationField"`
+
+	template       *template.Template
+	header         http.Header
+	requestTimeout time.Duration
+}
+
+// webSocketMessage is the data the message template is executed with.
+type webSocketMessage struct {
+	ID     string
+	Client uint64
+	Seq    uint64
+}
+
+// newFactory parses the message template and fills in the default origin.
+func (w *WebSocketRequesterFactory) newFactory(params *benchParams) (bench.RequesterFactory, error) {
+	var err error
+	if w.template, err = template.New("Message").Parse(w.Message); err != nil {
+		return nil, err
+	}
+
+	if w.Origin == "" {
+		u, err := url.Parse(w.URL)
+		if err != nil {
+			return nil, err
+		}
+		scheme := "http"
+		if u.Scheme == "wss" {
+			scheme = "https"
+		}
+		w.Origin = scheme + "://" + u.Host
+	}
+
+	w.header = make(http.Header)
+	for key, val := range w.Headers {
+		w.header.Set(key, os.ExpandEnv(val))
+	}
+	w.requestTimeout = params.RequestTimeout
+	return w, nil
+}
+
+func (w *WebSocketRequesterFactory) validate(errs *configErrors, path string) {
+	u, err := url.Parse(w.URL)
+	switch {
+	case w.URL == "":
+		errs.add(path+".URL", "must be set")
+	case err != nil:
+		errs.add(path+".URL", "%v", err)
+	case u.Scheme != "ws" && u.Scheme != "wss":
+		errs.add(path+".URL", "%q must start with ws:// or wss://", w.URL)
+	}
+
+	if w.Message == "" {
+		errs.add(path+".Message", "must be set")
+	} else if _, err := template.New("Message").Parse(w.Message); err != nil {
+		errs.add(path+".Message", "%v", err)
+	}
+}
+
+// GetRequester returns a new Requester, called for each Benchmark connection.
+func (w *WebSocketRequesterFactory) GetRequester(number uint64) bench.Requester {
+	return &webSocketRequester{factory: w, client: number}
+}
+
+// webSocketRequester implements Requester by sending a message over its
+// WebSocket connection and waiting for the reply.
+type webSocketRequester struct {
+	factory     *WebSocketRequesterFactory
+	client      uint64
+	seq         uint64
+	conn        *websocket.Conn
+	message     bytes.Buffer
+	timings     []bench.Timing
+	lastDetails bench.RequestDetails
+}
+
+// Setup opens the WebSocket connection.
+func (w *webSocketRequester) Setup() error {
+	return w.connect()
+}
+
+func (w *webSocketRequester) connect() error {
+	config, err := websocket.NewConfig(w.factory.URL, w.factory.Origin)
+	if err != nil {
+		return err
+	}
+	config.Header = w.factory.header
+	config.Dialer = &net.Dialer{Timeout: w.factory.requestTimeout}
+
+	before := time.Now()
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		return err
+	}
+	w.timings = append(w.timings, bench.Timing{Name: webSocketHandshakeTiming, Duration: time.Since(before)})
+	w.conn = conn
+	return nil
+}
+
+// Request sends a message and waits for its reply. A broken connection is
+// reopened right away by the failed Request. If that fails too, the next
+// Request reopens it instead of sending a message and fails.
+func (w *webSocketRequester) Request() error {
+	w.lastDetails = bench.RequestDetails{Target: w.factory.URL}
+
+	if w.conn == nil {
+		if err := w.connect(); err != nil {
+			return err
+		}
+		return errWebSocketReopened
+	}
+
+	seq := strconv.FormatUint(w.seq, 10)
+	id := strconv.FormatUint(w.client, 10) + "-" + seq
+	w.message.Reset()
+	if err := w.factory.template.Execute(&w.message, webSocketMessage{id, w.client, w.seq}); err != nil {
+		return err
+	}
+	w.seq++
+	w.lastDetails.BytesSent = int64(w.message.Len())
+
+	if w.factory.requestTimeout > 0 {
+		_ = w.conn.SetDeadline(time.Now().Add(w.factory.requestTimeout))
+	}
+	if err := websocket.Message.Send(w.conn, w.message.String()); err != nil {
+		w.reopen()
+		return err
+	}
+
+	for {
+		var reply []byte
+		if err := websocket.Message.Receive(w.conn, &reply); err != nil {
+			w.reopen()
+			return err
+		}
+		w.lastDetails.BytesReceived += int64(len(reply))
+
+		if w.factory.CorrelationField == "" {
+			return nil
+		}
+		if replyTo := correlationID(reply, w.factory.CorrelationField); replyTo == id || replyTo == seq {
+			return nil
+		}
+	}
+}
+
+// reopen replaces a broken connection, if that fails the connection is left
+// closed.
+func (w *webSocketRequester) reopen() {
+	_ = w.Teardown()
+	_ = w.connect()
+}
+
+// Teardown closes the WebSocket connection.
+func (w *webSocketRequester) Teardown() error {
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// TakeTimings returns the handshake latencies since the last call.
+func (w *webSocketRequester) TakeTimings() []bench.Timing {
+	timings := w.timings
+	w.timings = nil
+	return timings
+}
+
+// LastRequestDetails returns the details of the last Request call.
+func (w *webSocketRequester) LastRequestDetails() bench.RequestDetails { return w.lastDetails }
+
+// correlationID returns the string or the text of the number at the dot
+// separated path in a JSON message, or an empty string if the message has no
+// such field.
+func correlationID(message []byte, path string) string {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(message))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return ""
+	}
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		if v, ok = obj[key]; !ok {
+			return ""
+		}
+	}
+	switch id := v.(type) {
+	case string:
+		return id
+	case json.Number:
+		return id.String()
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestWebSocketRequesterReopen(t *testing.T) {
+	// Echoes a single message per connection, then closes it
+	server := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		var msg string
+		if err := websocket.Message.Receive(conn, &msg); err == nil {
+			_ = websocket.Message.Send(conn, msg)
+		}
+	}))
+	defer server.Close()
+
+	config := &WebSocketRequesterFactory{
+		URL:     "ws" + strings.TrimPrefix(server.URL, "http"),
+		Message: "{{.ID}}",
+	}
+	factory, err := config.newFactory(&benchParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := factory.GetRequester(0).(*webSocketRequester)
+	if err := r.Setup(); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	defer r.Teardown()
+
+	if err := r.Request(); err != nil {
+		t.Fatalf("first Request() error = %v", err)
+	}
+	// The connection is broken by now, the failing request reopens it
+	if err := r.Request(); err == nil {
+		t.Fatal("Request() on a closed connection succeeded")
+	}
+	if r.conn == nil {
+		t.Fatal("connection wasn't reopened")
+	}
+	if err := r.Request(); err != nil {
+		t.Fatalf("Request() after reopening error = %v", err)
+	}
+
+	if timings := r.TakeTimings(); len(timings) != 2 {
+		t.Errorf("%d handshake timings, want 2", len(timings))
+	}
+
+	// Without a server the connection stays closed and the next request
+	// fails with the connection error
+	server.Close()
+	_ = r.Request()
+	if err := r.Request(); err == nil || err == errWebSocketReopened {
+		t.Errorf("Request() without server error = %v", err)
+	}
+}
+
+func TestCorrelationID(t *testing.T) {
+	tests := []struct {
+		message, path string
+		want          string
+	}{
+		{`{"id": "0-1"}`, "id", "0-1"},
+		{`{"meta": {"id": "0-1"}, "id": "x"}`, "meta.id", "0-1"},
+		{`{"meta": {"id": 1}}`, "meta.id", "1"},
+		{`{"seq": 12345678901234567890}`, "seq", "12345678901234567890"},
+		{`{"id": null}`, "id", ""},
+		{`{"id": true}`, "id", ""},
+		{`{"meta": "0-1"}`, "meta.id", ""},
+		{`{"other": "0-1"}`, "id", ""},
+		{`["0-1"]`, "id", ""},
+		{`not json`, "id", ""},
+	}
+
+	for _, tt := range tests {
+		if got := correlationID([]byte(tt.message), tt.path); got != tt.want {
+			t.Errorf("correlationID(%s, %q) = %q, want %q", tt.message, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestWebSocketRequesterCorrelation(t *testing.T) {
+	// Sends an unrelated message and a reply to an older message before
+	// every reply
+	server := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		for {
+			var msg string
+			if err := websocket.Message.Receive(conn, &msg); err != nil {
+				return
+			}
+			_ = websocket.Message.Send(conn, `{"event": "tick"}`)
+			_ = websocket.Message.Send(conn, `{"id": "3-99", "seq": 99}`)
+			_ = websocket.Message.Send(conn, msg)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		message string
+		field   string
+	}{
+		{"string ID", `{"id": "{{.ID}}", "client": {{.Client}}, "seq": {{.Seq}}}`, "id"},
+		{"numeric Seq", `{"id": "{{.ID}}", "client": {{.Client}}, "seq": {{.Seq}}}`, "seq"},
+		{"string Seq", `{"id": "{{.Seq}}", "client": {{.Client}}, "seq": {{.Seq}}}`, "id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &WebSocketRequesterFactory{
+				URL:              "ws" + strings.TrimPrefix(server.URL, "http"),
+				Message:          tt.message,
+				CorrelationField: tt.field,
+			}
+			factory, err := config.newFactory(&benchParams{RequestTimeout: time.Second})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := factory.GetRequester(3).(*webSocketRequester)
+			if err := r.Setup(); err != nil {
+				t.Fatalf("Setup() error = %v", err)
+			}
+			defer r.Teardown()
+
+			for i := 0; i < 3; i++ {
+				if err := r.Request(); err != nil {
+					t.Fatalf("Request() error = %v", err)
+				}
+				details := r.LastRequestDetails()
+				skipped := int64(len(`{"event": "tick"}`) + len(`{"id": "3-99", "seq": 99}`))
+				if details.BytesReceived != details.BytesSent+skipped {
+					t.Errorf("bytes sent, received = %d, %d", details.BytesSent, details.BytesReceived)
+				}
+			}
+		})
+	}
+}